@@ -0,0 +1,160 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// apiChallengeResponse is returned by GET /api/v1/challenge
+type apiChallengeResponse struct {
+	Challenge  string    `json:"challenge"`
+	Difficulty int       `json:"difficulty"`
+	ExpiresAt  time.Time `json:"expires_at"`
+}
+
+// apiCreateLinkRequest is the body of POST /api/v1/links
+type apiCreateLinkRequest struct {
+	URL          string      `json:"url"`
+	Path         string      `json:"path"`
+	Lifetime     string      `json:"lifetime"`
+	SlugStyle    string      `json:"slug_style"`
+	PoWChallenge string      `json:"pow_challenge"`
+	PoWSolution  json.Number `json:"pow_solution"`
+}
+
+// apiLinkResponse describes a short link in API responses
+type apiLinkResponse struct {
+	Path      string     `json:"path"`
+	ShortURL  string     `json:"short_url"`
+	URL       string     `json:"url"`
+	CreatedAt *time.Time `json:"created_at,omitempty"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+
+	ManageToken string `json:"manage_token,omitempty"` // only returned when the link is created
+}
+
+// newAPILinkResponse describes the link stored at path, leaving out unknown creation and expiry times
+func newAPILinkResponse(path string, link Link) apiLinkResponse {
+	response := apiLinkResponse{
+		Path:     path,
+		ShortURL: "http://" + shortURLPrefix + path,
+		URL:      link.URL,
+	}
+	if !link.CreatedAt.IsZero() {
+		createdAt := link.CreatedAt.UTC()
+		response.CreatedAt = &createdAt
+	}
+	if !link.ExpiresAt.IsZero() {
+		expiresAt := link.ExpiresAt.UTC()
+		response.ExpiresAt = &expiresAt
+	}
+	return response
+}
+
+// apiError is the body of every API error response
+type apiError struct {
+	Error apiErrorDetail `json:"error"`
+}
+
+type apiErrorDetail struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// apiErrorResponse writes an error with a stable code and a human readable message
+func apiErrorResponse(c echo.Context, status int, code, message string) error {
+	return c.JSON(status, apiError{Error: apiErrorDetail{Code: code, Message: message}})
+}
+
+// apiStorageError writes the error for a failed storage call, telling clients to retry if storage timed out
+func apiStorageError(c echo.Context, err error, message string) error {
+	if isStorageTimeout(err) {
+		c.Response().Header().Set("Retry-After", storageRetryAfter)
+		return apiErrorResponse(c, http.StatusServiceUnavailable, "storage_unavailable", "storage is not answering in time, try again later")
+	}
+	return apiErrorResponse(c, http.StatusInternalServerError, "internal_error", message)
+}
+
+// handleAPIChallenge issues a proof of work challenge for API clients
+func handleAPIChallenge(c echo.Context) error {
+	challenge, difficulty, err := generateNewChallenge(APIProfile, c.RealIP())
+	if err != nil {
+		log.Printf("Failed to generate challenge: %v", err)
+		return apiErrorResponse(c, http.StatusInternalServerError, "internal_error", "error generating challenge")
+	}
+
+	return c.JSON(http.StatusOK, apiChallengeResponse{
+		Challenge:  challenge,
+		Difficulty: difficulty,
+		ExpiresAt:  time.Now().Add(challengeTTL).UTC(),
+	})
+}
+
+// handleAPICreateLink creates a short link, running the same checks as the shorten forms
+func handleAPICreateLink(c echo.Context) error {
+	var body apiCreateLinkRequest
+	if err := json.NewDecoder(c.Request().Body).Decode(&body); err != nil {
+		return apiErrorResponse(c, http.StatusBadRequest, "invalid_request", "request body must be a JSON object")
+	}
+
+	ctx, cancel := storageContext(c)
+	defer cancel()
+
+	link, err := createShortLink(ctx, ShortenRequest{
+		FullURL:   body.URL,
+		Path:      body.Path,
+		Lifetime:  body.Lifetime,
+		SlugStyle: body.SlugStyle,
+		Challenge: body.PoWChallenge,
+		Solution:  body.PoWSolution.String(),
+		Client:    ClientAPI,
+	}, c.RealIP())
+
+	var shortenErr *shortenError
+	if errors.As(err, &shortenErr) {
+		status := http.StatusBadRequest
+		if shortenErr.Code == "path_taken" {
+			status = http.StatusConflict
+		}
+		return apiErrorResponse(c, status, shortenErr.Code, shortenErr.Message)
+	}
+	if err != nil {
+		return apiStorageError(c, err, "internal server error")
+	}
+
+	response := newAPILinkResponse(link.Path, link.Link)
+	response.ManageToken = link.ManageToken
+	return c.JSON(http.StatusCreated, response)
+}
+
+// handleAPIGetLink resolves a short link
+func handleAPIGetLink(c echo.Context) error {
+	path := c.Param("path")
+
+	var pathErr *shortenError
+	if errors.As(pathPolicy.Validate(path), &pathErr) {
+		return apiErrorResponse(c, http.StatusBadRequest, pathErr.Code, pathErr.Message)
+	}
+
+	ctx, cancel := storageContext(c)
+	defer cancel()
+
+	link, path, exists, err := lookupLink(ctx, path)
+	if err != nil {
+		log.Printf("Failed to retrieve URL mapping for %s: %v", path, err)
+		return apiStorageError(c, err, "error retrieving URL mapping")
+	}
+	if !exists {
+		return apiErrorResponse(c, http.StatusNotFound, "not_found", "short link not found")
+	}
+	if isDisabled(link) {
+		return apiErrorResponse(c, http.StatusGone, "link_disabled", "short link was disabled")
+	}
+
+	return c.JSON(http.StatusOK, newAPILinkResponse(path, link))
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// newTestServer points the handlers at fresh in-memory storage and returns the echo instance
+func newTestServer(t *testing.T) *echo.Echo {
+	oldChallenges, oldLinks, oldSigner, oldDifficulty, oldRecorder := challengeStore, linkStore, challengeSigner, difficultyController, clickRecorder
+	storage := NewLocalMapStorage()
+	challengeStore, linkStore = storage, storage
+	challengeSigner = NewChallengeSigner([]byte("test secret"), challengeTTL)
+	difficultyController = NewDifficultyController()
+	clickRecorder = NewClickRecorder(linkStore)
+	reserved, err := loadReservedPaths("templates", "")
+	if err != nil {
+		t.Fatalf("failed to load reserved paths: %v", err)
+	}
+	oldReserved := reservedPaths
+	reservedPaths = reserved
+	t.Cleanup(func() {
+		reservedPaths = oldReserved
+		clickRecorder.Close()
+		storage.Close()
+		challengeStore, linkStore, challengeSigner, difficultyController, clickRecorder = oldChallenges, oldLinks, oldSigner, oldDifficulty, oldRecorder
+	})
+	return newServer()
+}
+
+// doJSON sends a request to e and decodes the JSON response into out
+func doJSON(t *testing.T, e *echo.Echo, method, target, body string, out interface{}) int {
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	if err := json.Unmarshal(rec.Body.Bytes(), out); err != nil {
+		t.Fatalf("%s %s returned invalid JSON %q: %v", method, target, rec.Body.String(), err)
+	}
+	return rec.Code
+}
+
+// solveChallenge finds a proof of work solution the same way captcha.js does
+func solveChallenge(challenge string, difficulty int) int {
+	for nonce := 0; ; nonce++ {
+		if VerifyProofOfWork(challenge, nonce, difficulty) {
+			return nonce
+		}
+	}
+}
+
+// fetchSolvedChallenge gets a challenge from the API and returns it with its solution
+func fetchSolvedChallenge(t *testing.T, e *echo.Echo) (string, int) {
+	var challenge apiChallengeResponse
+	if code := doJSON(t, e, http.MethodGet, "/api/v1/challenge", "", &challenge); code != http.StatusOK {
+		t.Fatalf("GET /api/v1/challenge = %d", code)
+	}
+	if challenge.Difficulty < APIProfile.Difficulty || challenge.Difficulty > APIProfile.MaxDifficulty {
+		t.Errorf("challenge difficulty = %d, expected between %d and %d", challenge.Difficulty, APIProfile.Difficulty, APIProfile.MaxDifficulty)
+	}
+	return challenge.Challenge, solveChallenge(challenge.Challenge, challenge.Difficulty)
+}
+
+func TestAPICreateAndGetLink(t *testing.T) {
+	e := newTestServer(t)
+
+	challenge, solution := fetchSolvedChallenge(t, e)
+	body, _ := json.Marshal(map[string]interface{}{
+		"url":           "example.com/page",
+		"path":          "api-test",
+		"lifetime":      "1h",
+		"pow_challenge": challenge,
+		"pow_solution":  solution,
+	})
+
+	var created apiLinkResponse
+	if code := doJSON(t, e, http.MethodPost, "/api/v1/links", string(body), &created); code != http.StatusCreated {
+		t.Fatalf("POST /api/v1/links = %d, expected %d", code, http.StatusCreated)
+	}
+	if created.Path != "api-test" || created.URL != "http://example.com/page" || created.ShortURL != "http://wap.fyi/api-test" {
+		t.Errorf("unexpected created link %+v", created)
+	}
+	if created.ExpiresAt == nil || time.Until(*created.ExpiresAt) > time.Hour {
+		t.Errorf("expires_at = %v, expected within an hour", created.ExpiresAt)
+	}
+
+	var fetched apiLinkResponse
+	if code := doJSON(t, e, http.MethodGet, "/api/v1/links/api-test", "", &fetched); code != http.StatusOK {
+		t.Fatalf("GET /api/v1/links/api-test = %d", code)
+	}
+	if fetched.URL != "http://example.com/page" {
+		t.Errorf("fetched URL = %s, expected http://example.com/page", fetched.URL)
+	}
+
+	// The stored link records how it was created
+	link, _, _ := linkStore.GetLink(context.Background(), "api-test")
+	if link.Client != ClientAPI || link.Difficulty < APIProfile.Difficulty || link.CreatorHash != hashCreatorIP("192.0.2.1") {
+		t.Errorf("stored link %+v does not record its creation", link)
+	}
+
+	// Disabled links are kept but gone
+	link.Disabled = true
+	if _, err := linkStore.UpdateLink(context.Background(), "api-test", link); err != nil {
+		t.Fatalf("UpdateLink failed: %v", err)
+	}
+	var disabled apiError
+	if code := doJSON(t, e, http.MethodGet, "/api/v1/links/api-test", "", &disabled); code != http.StatusGone || disabled.Error.Code != "link_disabled" {
+		t.Errorf("GET disabled link = %d %s, expected %d link_disabled", code, disabled.Error.Code, http.StatusGone)
+	}
+
+	// The same solution can not be used twice
+	var apiErr apiError
+	if code := doJSON(t, e, http.MethodPost, "/api/v1/links", string(body), &apiErr); code != http.StatusBadRequest || apiErr.Error.Code != "challenge_spent" {
+		t.Errorf("replayed solution = %d %s, expected %d challenge_spent", code, apiErr.Error.Code, http.StatusBadRequest)
+	}
+}
+
+func TestAPIErrorCodes(t *testing.T) {
+	e := newTestServer(t)
+
+	tests := []struct {
+		name   string
+		fields map[string]interface{}
+		status int
+		code   string
+	}{
+		{"no challenge", map[string]interface{}{"url": "http://example.com"}, http.StatusBadRequest, "challenge_required"},
+		{"missing url", map[string]interface{}{}, http.StatusBadRequest, "url_required"},
+		{"bad path", map[string]interface{}{"url": "http://example.com", "path": "a/b"}, http.StatusBadRequest, "invalid_path_format"},
+		{"long url", map[string]interface{}{"url": "http://example.com/" + strings.Repeat("a", 200)}, http.StatusBadRequest, "url_too_long"},
+		{"bad url", map[string]interface{}{"url": "ftp://example.com"}, http.StatusBadRequest, "invalid_url"},
+		{"bad lifetime", map[string]interface{}{"url": "http://example.com", "lifetime": "1y"}, http.StatusBadRequest, "invalid_lifetime"},
+		{"taken path", map[string]interface{}{"url": "http://example.com", "path": "taken"}, http.StatusConflict, "path_taken"},
+	}
+
+	storeTestLink(t, linkStore, "taken", Link{URL: "http://example.org"})
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.code != "challenge_required" {
+				tt.fields["pow_challenge"], tt.fields["pow_solution"] = fetchSolvedChallenge(t, e)
+			}
+			body, _ := json.Marshal(tt.fields)
+
+			var apiErr apiError
+			code := doJSON(t, e, http.MethodPost, "/api/v1/links", string(body), &apiErr)
+			if code != tt.status || apiErr.Error.Code != tt.code {
+				t.Errorf("got %d %s, expected %d %s", code, apiErr.Error.Code, tt.status, tt.code)
+			}
+		})
+	}
+
+	var apiErr apiError
+	if code := doJSON(t, e, http.MethodGet, "/api/v1/links/missing", "", &apiErr); code != http.StatusNotFound || apiErr.Error.Code != "not_found" {
+		t.Errorf("GET missing link = %d %s, expected %d not_found", code, apiErr.Error.Code, http.StatusNotFound)
+	}
+	if code := doJSON(t, e, http.MethodPost, "/api/v1/links", "not json", &apiErr); code != http.StatusBadRequest || apiErr.Error.Code != "invalid_request" {
+		t.Errorf("invalid body = %d %s, expected %d invalid_request", code, apiErr.Error.Code, http.StatusBadRequest)
+	}
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+	"os/signal"
+	"regexp"
+	"strings"
+	"sync"
+	"syscall"
+)
+
+// blocklist is checked for every new link and every redirect, it is empty unless BLOCKLIST_FILE is set
+var blocklist = NewBlocklist("")
+
+// blocklistRules are the rules parsed from one version of the blocklist file
+type blocklistRules struct {
+	domains  map[string]bool // hosts blocked exactly
+	suffixes []string        // hosts blocked with all their subdomains
+	regexps  []*regexp.Regexp
+}
+
+// Blocklist blocks destination URLs by domain, domain suffix or regular expression.
+// Rules are read from a file with one rule per line:
+//
+//	# comments and blank lines are ignored
+//	evil.example            blocks this host only, same as domain:evil.example
+//	suffix:phish.example    blocks phish.example and all of its subdomains
+//	regex:paypa1\.          blocks every URL matching the expression
+type Blocklist struct {
+	path  string
+	rules *blocklistRules
+	mu    sync.RWMutex
+}
+
+// NewBlocklist creates a blocklist reading its rules from path. It is empty until Reload is called.
+func NewBlocklist(path string) *Blocklist {
+	return &Blocklist{
+		path:  path,
+		rules: &blocklistRules{domains: make(map[string]bool)},
+	}
+}
+
+// parseBlocklist parses blocklist rules, reporting the line of the first invalid rule
+func parseBlocklist(f *os.File) (*blocklistRules, error) {
+	rules := &blocklistRules{domains: make(map[string]bool)}
+
+	scanner := bufio.NewScanner(f)
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		kind, value, found := strings.Cut(line, ":")
+		if !found {
+			kind, value = "domain", line
+		}
+		value = strings.TrimSpace(value)
+		if value == "" {
+			return nil, fmt.Errorf("line %d: empty %s rule", lineNo, kind)
+		}
+
+		switch kind {
+		case "domain":
+			rules.domains[normalizeHost(value)] = true
+		case "suffix":
+			rules.suffixes = append(rules.suffixes, strings.TrimPrefix(normalizeHost(value), "."))
+		case "regex":
+			re, err := regexp.Compile(value)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %w", lineNo, err)
+			}
+			rules.regexps = append(rules.regexps, re)
+		default:
+			return nil, fmt.Errorf("line %d: unknown rule type %q", lineNo, kind)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return rules, nil
+}
+
+// Reload reads the blocklist file again. The old rules stay in place if the file is invalid.
+func (b *Blocklist) Reload() error {
+	if b.path == "" {
+		return nil
+	}
+
+	f, err := os.Open(b.path)
+	if err != nil {
+		return fmt.Errorf("failed to open blocklist: %w", err)
+	}
+	defer f.Close()
+
+	rules, err := parseBlocklist(f)
+	if err != nil {
+		return fmt.Errorf("invalid blocklist %s: %w", b.path, err)
+	}
+
+	b.mu.Lock()
+	b.rules = rules
+	b.mu.Unlock()
+
+	log.Printf("Loaded blocklist %s with %d domain, %d suffix and %d regex rules", b.path, len(rules.domains), len(rules.suffixes), len(rules.regexps))
+	return nil
+}
+
+// ReloadOnSIGHUP reloads the blocklist every time the process receives SIGHUP
+func (b *Blocklist) ReloadOnSIGHUP() {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP)
+
+	go func() {
+		for range signals {
+			if err := b.Reload(); err != nil {
+				log.Printf("Failed to reload blocklist, keeping the old rules: %v", err)
+			}
+		}
+	}()
+}
+
+// normalizeHost lowercases a host name and strips its trailing dot
+func normalizeHost(host string) string {
+	return strings.TrimSuffix(strings.ToLower(host), ".")
+}
+
+// Blocks reports whether a destination URL matches any rule of the blocklist
+func (b *Blocklist) Blocks(rawURL string) bool {
+	b.mu.RLock()
+	rules := b.rules
+	b.mu.RUnlock()
+
+	for _, re := range rules.regexps {
+		if re.MatchString(rawURL) {
+			return true
+		}
+	}
+
+	parsedURL, err := url.Parse(rawURL)
+	if err != nil {
+		return false
+	}
+	host := normalizeHost(parsedURL.Hostname())
+
+	if rules.domains[host] {
+		return true
+	}
+	for _, suffix := range rules.suffixes {
+		if host == suffix || strings.HasSuffix(host, "."+suffix) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// writeBlocklist writes rules to a blocklist file in a temporary directory
+func writeBlocklist(t *testing.T, path, rules string) {
+	if err := os.WriteFile(path, []byte(rules), 0600); err != nil {
+		t.Fatalf("failed to write blocklist: %v", err)
+	}
+}
+
+func TestBlocklist(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "blocklist.txt")
+	writeBlocklist(t, path, `# test rules
+evil.example
+domain:Bad.Example.
+suffix:phish.example
+regex:paypa1\.
+`)
+
+	b := NewBlocklist(path)
+	if err := b.Reload(); err != nil {
+		t.Fatalf("Reload failed: %v", err)
+	}
+
+	tests := []struct {
+		url     string
+		blocked bool
+	}{
+		{"http://evil.example/login", true},
+		{"http://EVIL.example:8080/", true},
+		{"http://www.evil.example/", false},
+		{"http://bad.example/", true},
+		{"http://phish.example/", true},
+		{"https://login.phish.example/", true},
+		{"http://notphish.example/", false},
+		{"http://www.paypa1.example/", true},
+		{"http://example.com/", false},
+	}
+	for _, tt := range tests {
+		if blocked := b.Blocks(tt.url); blocked != tt.blocked {
+			t.Errorf("Blocks(%s) = %t, expected %t", tt.url, blocked, tt.blocked)
+		}
+	}
+
+	// An invalid file keeps the rules that were loaded before
+	writeBlocklist(t, path, "regex:(\n")
+	if err := b.Reload(); err == nil {
+		t.Errorf("Reload accepted an invalid regex")
+	}
+	if !b.Blocks("http://evil.example/") {
+		t.Errorf("invalid reload dropped the old rules")
+	}
+
+	writeBlocklist(t, path, "example.com\n")
+	if err := b.Reload(); err != nil {
+		t.Fatalf("Reload failed: %v", err)
+	}
+	if b.Blocks("http://evil.example/") || !b.Blocks("http://example.com/") {
+		t.Errorf("reload did not replace the rules")
+	}
+}
+
+func TestBlockedLinks(t *testing.T) {
+	e := newTestServer(t)
+
+	path := filepath.Join(t.TempDir(), "blocklist.txt")
+	writeBlocklist(t, path, "suffix:evil.example\n")
+	oldBlocklist := blocklist
+	blocklist = NewBlocklist(path)
+	t.Cleanup(func() { blocklist = oldBlocklist })
+	if err := blocklist.Reload(); err != nil {
+		t.Fatalf("Reload failed: %v", err)
+	}
+
+	// New links to blocked hosts are refused
+	challenge, solution := fetchSolvedChallenge(t, e)
+	body, _ := json.Marshal(map[string]interface{}{
+		"url":           "http://www.evil.example/",
+		"pow_challenge": challenge,
+		"pow_solution":  solution,
+	})
+	var apiErr apiError
+	if code := doJSON(t, e, http.MethodPost, "/api/v1/links", string(body), &apiErr); code != http.StatusBadRequest || apiErr.Error.Code != "url_blocked" {
+		t.Errorf("blocked URL = %d %s, expected %d url_blocked", code, apiErr.Error.Code, http.StatusBadRequest)
+	}
+
+	// Links created before the host was blocked stop resolving
+	storeTestLink(t, linkStore, "old", Link{URL: "http://evil.example/"})
+	for _, tt := range []struct {
+		target string
+		accept string
+		status int
+	}{
+		{"/old", "text/html", http.StatusGone},
+		{"/old+", "text/html", http.StatusGone},
+		{"/old", "text/vnd.wap.wml", http.StatusOK},
+	} {
+		req := httptest.NewRequest(http.MethodGet, tt.target, nil)
+		req.Header.Set("Accept", tt.accept)
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		if rec.Code != tt.status || rec.Header().Get("Location") != "" {
+			t.Errorf("GET %s (%s) = %d to %q, expected %d without a redirect", tt.target, tt.accept, rec.Code, rec.Header().Get("Location"), tt.status)
+		}
+	}
+	if code := doJSON(t, e, http.MethodGet, "/api/v1/links/old", "", &apiErr); code != http.StatusGone || apiErr.Error.Code != "link_disabled" {
+		t.Errorf("GET /api/v1/links/old = %d %s, expected %d link_disabled", code, apiErr.Error.Code, http.StatusGone)
+	}
+}
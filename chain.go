@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/url"
+	"strings"
+)
+
+// maxLinkChain is how many short links a destination may pass through before it leaves our hosts
+const maxLinkChain = 3
+
+// defaultPublicHosts are the hostnames this shortener answers on when PUBLIC_HOSTS is not set
+const defaultPublicHosts = "wap.fyi,www.wap.fyi"
+
+var (
+	// publicHosts are the hostnames our short links live on, destinations on them are followed through storage
+	publicHosts = parseHostList(defaultPublicHosts)
+	// otherShorteners are the hosts of other link shorteners, whose chains we can't follow
+	otherShorteners = parseHostList("")
+	// refuseOtherShorteners refuses every destination on otherShorteners, not only over-long chains
+	refuseOtherShorteners = false
+)
+
+// parseHostList parses a comma separated list of hostnames
+func parseHostList(hosts string) map[string]bool {
+	list := make(map[string]bool)
+	for _, host := range strings.Split(hosts, ",") {
+		if host = normalizeHost(strings.TrimSpace(host)); host != "" {
+			list[host] = true
+		}
+	}
+	return list
+}
+
+// isOtherShortener reports whether host or one of its parent domains is another link shortener
+func isOtherShortener(host string) bool {
+	for {
+		if otherShorteners[host] {
+			return true
+		}
+		_, parent, found := strings.Cut(host, ".")
+		if !found {
+			return false
+		}
+		host = parent
+	}
+}
+
+// checkLinkChain follows a destination through our own short links and rejects it if it
+// leads back to path or passes through more than maxLinkChain links. A link on another
+// shortener can't be followed but counts as one more link, or is refused with refuseOtherShorteners.
+// Returns a *shortenError if the destination is rejected, or any other error on internal failures.
+func checkLinkChain(ctx context.Context, path string, fullURL string) error {
+	// With fuzzyPaths, paths that fold the same lead to the same link
+	visitKey := func(p string) string {
+		if fuzzyPaths {
+			return foldPath(p)
+		}
+		return p
+	}
+	visited := map[string]bool{visitKey(path): true}
+
+	for hops := 0; ; hops++ {
+		parsedURL, err := url.Parse(fullURL)
+		if err != nil {
+			return &shortenError{Code: "invalid_url", Message: "invalid full URL format"}
+		}
+
+		host := normalizeHost(parsedURL.Hostname())
+		if isOtherShortener(host) {
+			if refuseOtherShorteners {
+				return &shortenError{Code: "url_shortener", Message: "links to other URL shorteners are not allowed"}
+			}
+			if hops >= maxLinkChain {
+				return &shortenError{Code: "url_chain_too_long", Message: "destination goes through too many short links"}
+			}
+			return nil // We can't see where it goes from there
+		}
+		if !publicHosts[host] {
+			return nil // The chain leaves our hosts
+		}
+
+		// Only paths that handleRedirectOrStatic redirects are links, anything else is one of our pages
+		next := strings.TrimPrefix(parsedURL.Path, "/")
+		if !pathPolicy.Allows(next) {
+			return nil
+		}
+		if visited[visitKey(next)] {
+			return &shortenError{Code: "url_loop", Message: "destination leads back to this link"}
+		}
+		if hops >= maxLinkChain {
+			return &shortenError{Code: "url_chain_too_long", Message: "destination goes through too many short links"}
+		}
+
+		link, stored, exists, err := lookupLink(ctx, next)
+		if err != nil {
+			log.Printf("Failed to follow link chain through %s: %v", next, err)
+			return err
+		}
+		if !exists {
+			return nil // A dead end, which can't loop back
+		}
+		if visited[visitKey(stored)] {
+			return &shortenError{Code: "url_loop", Message: "destination leads back to this link"}
+		}
+		visited[visitKey(next)] = true
+		visited[visitKey(stored)] = true
+		fullURL = link.URL
+	}
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestCheckLinkChain(t *testing.T) {
+	newTestServer(t)
+	oldShorteners := otherShorteners
+	otherShorteners = parseHostList("bit.ly")
+	t.Cleanup(func() { otherShorteners = oldShorteners })
+
+	links := map[string]string{
+		"a":  "http://wap.fyi/b",
+		"b":  "http://example.com",
+		"l1": "http://wap.fyi/l2",
+		"l2": "https://www.wap.fyi/l3",
+		"l3": "http://WAP.FYI./l4?x=1",
+		"l4": "http://example.com",
+		"s1": "http://wap.fyi/s2",
+		"s2": "http://wap.fyi/s3",
+		"s3": "http://bit.ly/abc",
+	}
+	for path, fullURL := range links {
+		storeTestLink(t, linkStore, path, Link{URL: fullURL})
+	}
+
+	checkChains(t, []chainTest{
+		{"new", "http://example.com/wap.fyi/a", ""},
+		{"new", "http://wap.fyi/", ""},
+		{"new", "http://wap.fyi/a+", ""},
+		{"new", "http://wap.fyi/missing", ""},
+		{"new", "http://wap.fyi/a", ""},
+		{"new", "http://wap.fyi/l2", ""},
+		{"self", "http://wap.fyi/self", "url_loop"},
+		{"self", "http://wap.fyi:80/self", "url_loop"},
+		{"b", "http://wap.fyi/a", "url_loop"},
+		{"new", "http://wap.fyi/l1", "url_chain_too_long"},
+		{"new", "http://bit.ly/abc", ""},
+		{"new", "http://wap.fyi/s2", ""},
+		{"new", "http://wap.fyi/s1", "url_chain_too_long"}, // bit.ly is one more link
+	})
+
+	// Other shorteners can be refused outright
+	refuseOtherShorteners = true
+	t.Cleanup(func() { refuseOtherShorteners = false })
+	checkChains(t, []chainTest{
+		{"new", "http://bit.ly/abc", "url_shortener"},
+		{"new", "https://m.bit.ly/abc", "url_shortener"},
+		{"new", "http://wap.fyi/s3", "url_shortener"},
+		{"new", "http://example.com", ""},
+	})
+}
+
+// chainTest is a destination for the link at path and the error code checkLinkChain returns for it
+type chainTest struct {
+	path    string
+	fullURL string
+	code    string
+}
+
+// checkChains runs checkLinkChain on each destination and checks the error code it returns
+func checkChains(t *testing.T, tests []chainTest) {
+	t.Helper()
+	for _, tt := range tests {
+		err := checkLinkChain(context.Background(), tt.path, tt.fullURL)
+		var shortenErr *shortenError
+		switch {
+		case tt.code == "" && err != nil:
+			t.Errorf("checkLinkChain(context.Background(), %s, %s) = %v, expected no error", tt.path, tt.fullURL, err)
+		case tt.code != "" && (!errors.As(err, &shortenErr) || shortenErr.Code != tt.code):
+			t.Errorf("checkLinkChain(context.Background(), %s, %s) = %v, expected %s", tt.path, tt.fullURL, err, tt.code)
+		}
+	}
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// challengeIDLength is the length of the random ID that makes every challenge unique
+	challengeIDLength = 16
+	// challengeTTL is how long an issued challenge can be solved
+	challengeTTL = time.Hour
+	// challengeClockSkew is how far in the future an issue time may lie before a challenge is rejected
+	challengeClockSkew = time.Minute
+	// challengeTagLength is the number of HMAC bytes kept in the challenge
+	challengeTagLength = 16
+)
+
+var (
+	errInvalidChallenge = errors.New("invalid challenge")
+	errChallengeExpired = errors.New("challenge expired")
+)
+
+// Challenge holds the fields signed into a proof of work challenge
+type Challenge struct {
+	ID         string
+	IssuedAt   time.Time
+	Difficulty int
+}
+
+// ChallengeSigner issues and verifies stateless, HMAC signed proof of work challenges.
+// A challenge looks like <id>.<issued at, base 36>.<difficulty>.<tag> and only uses
+// characters that captcha.js and captcha.wmls can hash.
+type ChallengeSigner struct {
+	secret []byte
+	ttl    time.Duration
+	now    func() time.Time
+}
+
+// NewChallengeSigner creates a signer using the given server secret
+func NewChallengeSigner(secret []byte, ttl time.Duration) *ChallengeSigner {
+	return &ChallengeSigner{
+		secret: secret,
+		ttl:    ttl,
+		now:    time.Now,
+	}
+}
+
+// NewRandomChallengeSigner creates a signer with a random secret, for when none is configured.
+// Challenges issued by it are only valid on this instance until it restarts.
+func NewRandomChallengeSigner(ttl time.Duration) (*ChallengeSigner, error) {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return nil, err
+	}
+	return NewChallengeSigner(secret, ttl), nil
+}
+
+// Issue creates a new signed challenge with the given difficulty
+func (s *ChallengeSigner) Issue(difficulty int) (string, error) {
+	id, err := generateRandomString(challengeIDLength)
+	if err != nil {
+		return "", err
+	}
+
+	payload := fmt.Sprintf("%s.%s.%d", id, strconv.FormatInt(s.now().Unix(), 36), difficulty)
+	return payload + "." + s.sign(payload), nil
+}
+
+// Verify checks the signature and age of a challenge and returns its fields
+func (s *ChallengeSigner) Verify(challenge string) (Challenge, error) {
+	fields := strings.Split(challenge, ".")
+	if len(fields) != 4 {
+		return Challenge{}, errInvalidChallenge
+	}
+
+	payload := strings.Join(fields[:3], ".")
+	if !hmac.Equal([]byte(fields[3]), []byte(s.sign(payload))) {
+		return Challenge{}, errInvalidChallenge
+	}
+
+	issuedAt, err := strconv.ParseInt(fields[1], 36, 64)
+	if err != nil {
+		return Challenge{}, errInvalidChallenge
+	}
+	difficulty, err := strconv.Atoi(fields[2])
+	if err != nil || difficulty < 1 || difficulty > 8 {
+		return Challenge{}, errInvalidChallenge
+	}
+
+	parsed := Challenge{
+		ID:         fields[0],
+		IssuedAt:   time.Unix(issuedAt, 0),
+		Difficulty: difficulty,
+	}
+
+	now := s.now()
+	if parsed.IssuedAt.After(now.Add(challengeClockSkew)) {
+		return Challenge{}, errInvalidChallenge
+	}
+	if now.Sub(parsed.IssuedAt) > s.ttl {
+		return Challenge{}, errChallengeExpired
+	}
+
+	return parsed, nil
+}
+
+// sign computes the truncated HMAC tag of a challenge payload
+func (s *ChallengeSigner) sign(payload string) string {
+	mac := hmac.New(sha256.New, s.secret)
+	mac.Write([]byte(payload))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil)[:challengeTagLength])
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestChallengeSignerRoundTrip(t *testing.T) {
+	signer := NewChallengeSigner([]byte("secret"), time.Hour)
+
+	challenge, err := signer.Issue(3)
+	if err != nil {
+		t.Fatalf("Issue failed: %v", err)
+	}
+
+	parsed, err := signer.Verify(challenge)
+	if err != nil {
+		t.Fatalf("Verify(%s) failed: %v", challenge, err)
+	}
+	if parsed.Difficulty != 3 || len(parsed.ID) != challengeIDLength {
+		t.Errorf("Verify(%s) = %+v, expected difficulty 3 and a %d character ID", challenge, parsed, challengeIDLength)
+	}
+}
+
+func TestChallengeSignerRejectsTampering(t *testing.T) {
+	signer := NewChallengeSigner([]byte("secret"), time.Hour)
+	other := NewChallengeSigner([]byte("other secret"), time.Hour)
+
+	challenge, err := signer.Issue(4)
+	if err != nil {
+		t.Fatalf("Issue failed: %v", err)
+	}
+	fields := strings.Split(challenge, ".")
+
+	testCases := []string{
+		"",
+		"not a challenge",
+		strings.Join([]string{fields[0], fields[1], "1", fields[3]}, "."), // lowered difficulty
+		strings.Join([]string{"AAAAAAAAAAAAAAAA", fields[1], fields[2], fields[3]}, "."),
+		challenge + "x",
+	}
+	for _, tc := range testCases {
+		if _, err := signer.Verify(tc); err != errInvalidChallenge {
+			t.Errorf("Verify(%q) = %v, expected %v", tc, err, errInvalidChallenge)
+		}
+	}
+
+	if _, err := other.Verify(challenge); err != errInvalidChallenge {
+		t.Errorf("challenge signed with another secret verified: %v", err)
+	}
+}
+
+func TestChallengeSignerExpiry(t *testing.T) {
+	now := time.Date(2001, 9, 1, 12, 0, 0, 0, time.UTC)
+	signer := NewChallengeSigner([]byte("secret"), time.Hour)
+	signer.now = func() time.Time { return now }
+
+	challenge, err := signer.Issue(4)
+	if err != nil {
+		t.Fatalf("Issue failed: %v", err)
+	}
+
+	now = now.Add(59 * time.Minute)
+	if _, err := signer.Verify(challenge); err != nil {
+		t.Errorf("challenge should still be valid after 59 minutes: %v", err)
+	}
+
+	now = now.Add(2 * time.Minute)
+	if _, err := signer.Verify(challenge); err != errChallengeExpired {
+		t.Errorf("Verify after 61 minutes = %v, expected %v", err, errChallengeExpired)
+	}
+
+	now = now.Add(-3 * time.Hour)
+	if _, err := signer.Verify(challenge); err != errInvalidChallenge {
+		t.Errorf("Verify of a challenge from the future = %v, expected %v", err, errInvalidChallenge)
+	}
+}
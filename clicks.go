@@ -0,0 +1,135 @@
+package main
+
+import (
+	"context"
+	"log"
+	"regexp"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// Client classes clicks are broken down by
+const (
+	ClientWAP  = "wap"
+	ClientHTML = "html"
+	ClientBot  = "bot"
+
+	// ClientAPI is the class of links created through the JSON API, it never shows up in clicks
+	ClientAPI = "api"
+)
+
+// clientClasses lists the client classes in the order they are shown on the stats page
+var clientClasses = []string{ClientWAP, ClientHTML, ClientBot}
+
+// clickDayFormat formats the UTC day of the daily click buckets
+const clickDayFormat = "2006-01-02"
+
+// clickQueueSize is how many clicks can wait to be stored before new ones are dropped
+const clickQueueSize = 1024
+
+// botUserAgent matches the user agents of crawlers and link unfurlers
+var botUserAgent = regexp.MustCompile(`(?i)bot|crawl|spider|slurp|facebookexternalhit|embedly|preview|curl|wget|python-requests|go-http-client`)
+
+// Click is a single followed short link
+type Click struct {
+	Class     string    // one of ClientWAP, ClientHTML or ClientBot
+	At        time.Time // when the link was followed
+	ExpiresAt time.Time // expiry of the link, its stats expire with it
+}
+
+// ClickStats are the recorded clicks of a short link
+type ClickStats struct {
+	Total   int64
+	Classes map[string]int64 // clicks per client class
+	Days    map[string]int64 // clicks per UTC day, formatted with clickDayFormat
+}
+
+// newClickStats creates empty click stats
+func newClickStats() ClickStats {
+	return ClickStats{
+		Classes: make(map[string]int64),
+		Days:    make(map[string]int64),
+	}
+}
+
+// add counts a click in the stats
+func (s *ClickStats) add(click Click) {
+	s.Total++
+	s.Classes[click.Class]++
+	s.Days[click.At.UTC().Format(clickDayFormat)]++
+}
+
+// classifyClient tells bots, WAP handsets and HTML browsers apart
+func classifyClient(c echo.Context) string {
+	if botUserAgent.MatchString(c.Request().UserAgent()) {
+		return ClientBot
+	}
+	if acceptsWML(c) {
+		return ClientWAP
+	}
+	return ClientHTML
+}
+
+// ClickRecorder stores clicks in the background so redirects never wait on storage
+type ClickRecorder struct {
+	storage   LinkStore
+	queue     chan clickEvent
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// clickEvent is a click waiting to be stored
+type clickEvent struct {
+	path  string
+	click Click
+}
+
+// clickRecorder records the clicks of all redirects
+var clickRecorder *ClickRecorder
+
+// NewClickRecorder starts a recorder storing clicks in storage
+func NewClickRecorder(storage LinkStore) *ClickRecorder {
+	r := &ClickRecorder{
+		storage: storage,
+		queue:   make(chan clickEvent, clickQueueSize),
+		done:    make(chan struct{}),
+	}
+	go r.run()
+
+	return r
+}
+
+// Record queues a click on the link at path. It never blocks, when the queue
+// is full the click is dropped.
+func (r *ClickRecorder) Record(path string, click Click) {
+	select {
+	case r.queue <- clickEvent{path: path, click: click}:
+	default:
+		log.Printf("Click queue is full, dropping click on %s", path)
+	}
+}
+
+// run stores queued clicks until the recorder is closed
+func (r *ClickRecorder) run() {
+	defer close(r.done)
+
+	for event := range r.queue {
+		// Clicks are recorded after their request is done, so they get a deadline of their own
+		ctx, cancel := context.WithTimeout(context.Background(), storageTimeout)
+		err := r.storage.RecordClick(ctx, event.path, event.click)
+		cancel()
+		if err != nil {
+			log.Printf("Failed to record click on %s: %v", event.path, err)
+		}
+	}
+}
+
+// Close stores the clicks that are still queued and stops the recorder
+func (r *ClickRecorder) Close() {
+	r.closeOnce.Do(func() {
+		close(r.queue)
+	})
+	<-r.done
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRedirectRecordsClicks(t *testing.T) {
+	e := newTestServer(t)
+	statsToken = "secret"
+	t.Cleanup(func() { statsToken = "" })
+
+	storeTestLink(t, linkStore, "counted", Link{URL: "http://example.com"})
+
+	requests := []struct {
+		accept    string
+		userAgent string
+	}{
+		{"text/vnd.wap.wml", "Nokia7110/1.0 (04.84)"},
+		{"text/html", "Mozilla/4.0 (compatible; MSIE 6.0; Windows 98)"},
+		{"text/html", "Googlebot/2.1 (+http://www.google.com/bot.html)"},
+		{"text/html", "Mozilla/4.0 (compatible; MSIE 6.0; Windows 98)"},
+	}
+	for _, r := range requests {
+		req := httptest.NewRequest(http.MethodGet, "/counted", nil)
+		req.Header.Set("Accept", r.accept)
+		req.Header.Set("User-Agent", r.userAgent)
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		if rec.Code != http.StatusFound {
+			t.Fatalf("GET /counted = %d, expected a redirect", rec.Code)
+		}
+	}
+
+	// Previews are not clicks
+	req := httptest.NewRequest(http.MethodGet, "/counted+", nil)
+	e.ServeHTTP(httptest.NewRecorder(), req)
+
+	// Wait for the queued clicks to be stored
+	clickRecorder.Close()
+
+	stats, err := linkStore.GetClickStats(context.Background(), "counted")
+	if err != nil {
+		t.Fatalf("GetClickStats failed: %v", err)
+	}
+	if stats.Total != 4 || stats.Classes[ClientWAP] != 1 || stats.Classes[ClientHTML] != 2 || stats.Classes[ClientBot] != 1 {
+		t.Errorf("unexpected stats %+v", stats)
+	}
+
+	for _, tt := range []struct {
+		target string
+		status int
+	}{
+		{"/stats/counted", http.StatusNotFound},
+		{"/stats/counted?token=wrong", http.StatusNotFound},
+		{"/stats/missing?token=secret", http.StatusNotFound},
+		{"/stats/counted?token=secret", http.StatusOK},
+	} {
+		req := httptest.NewRequest(http.MethodGet, tt.target, nil)
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		if rec.Code != tt.status {
+			t.Errorf("GET %s = %d, expected %d", tt.target, rec.Code, tt.status)
+		}
+		if rec.Code == http.StatusOK && !strings.Contains(rec.Body.String(), "<td>html</td><td align=\"right\">2</td>") {
+			t.Errorf("stats page does not show the HTML clicks:\n%s", rec.Body.String())
+		}
+	}
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+)
+
+func TestParseTrustedProxies(t *testing.T) {
+	proxies, err := parseTrustedProxies(" 10.0.0.0/8, 192.0.2.7 ,::1")
+	if err != nil {
+		t.Fatalf("parseTrustedProxies failed: %v", err)
+	}
+	if len(proxies) != 3 || proxies[0].String() != "10.0.0.0/8" || proxies[1].String() != "192.0.2.7/32" || proxies[2].String() != "::1/128" {
+		t.Errorf("parseTrustedProxies = %v", proxies)
+	}
+
+	for _, list := range []string{"10.0.0.300", "10.0.0.0/33", "proxy.example"} {
+		if _, err := parseTrustedProxies(list); err == nil {
+			t.Errorf("parseTrustedProxies(%q) succeeded, expected an error", list)
+		}
+	}
+}
+
+func TestClientIPExtractor(t *testing.T) {
+	proxies, _ := parseTrustedProxies("192.0.2.0/24")
+
+	tests := []struct {
+		proxies    []*net.IPNet
+		remoteAddr string
+		expected   string
+	}{
+		{nil, "203.0.113.5:1234", "203.0.113.5"},
+		{nil, "127.0.0.1:1234", "127.0.0.1"},
+		{proxies, "192.0.2.1:1234", "203.0.113.9"},
+		{proxies, "198.51.100.1:1234", "198.51.100.1"},
+	}
+	for _, tt := range tests {
+		e := echo.New()
+		e.IPExtractor = clientIPExtractor(tt.proxies)
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.RemoteAddr = tt.remoteAddr
+		req.Header.Set(echo.HeaderXForwardedFor, "203.0.113.9")
+		req.Header.Set(echo.HeaderXRealIP, "203.0.113.10")
+		if got := e.NewContext(req, httptest.NewRecorder()).RealIP(); got != tt.expected {
+			t.Errorf("RealIP from %s with proxies %v = %s, expected %s", tt.remoteAddr, tt.proxies, got, tt.expected)
+		}
+	}
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	// rateBuckets is the number of one minute buckets in the sliding rate window
+	rateBuckets = 10
+	// rateGlobalStep is the number of shorten requests from everyone in the window that adds one difficulty level
+	rateGlobalStep = 60
+	// rateClientStep is the number of shorten requests from a single client in the window that adds one difficulty level
+	rateClientStep = 5
+	// rateMaxClients is the number of client IPs tracked at once, the least recently seen makes way for new ones
+	rateMaxClients = 10000
+)
+
+// rateWindow counts events in one minute buckets over the last rateBuckets minutes
+type rateWindow struct {
+	counts  [rateBuckets]int
+	minutes [rateBuckets]int64
+}
+
+// add counts an event at now
+func (w *rateWindow) add(now time.Time) {
+	minute := now.Unix() / 60
+	i := minute % rateBuckets
+	if w.minutes[i] != minute {
+		w.minutes[i] = minute
+		w.counts[i] = 0
+	}
+	w.counts[i]++
+}
+
+// lastMinute returns the minute of the most recent event
+func (w *rateWindow) lastMinute() int64 {
+	last := w.minutes[0]
+	for _, minute := range w.minutes[1:] {
+		if minute > last {
+			last = minute
+		}
+	}
+	return last
+}
+
+// count returns the number of events in the window ending at now
+func (w *rateWindow) count(now time.Time) int {
+	minute := now.Unix() / 60
+	total := 0
+	for i := range w.counts {
+		if minute-w.minutes[i] < rateBuckets {
+			total += w.counts[i]
+		}
+	}
+	return total
+}
+
+// DifficultyController picks the proof of work difficulty for new challenges
+// from the recent shorten rate, globally and per client IP
+type DifficultyController struct {
+	mu        sync.Mutex
+	global    rateWindow
+	clients   map[string]*rateWindow
+	lastPrune time.Time
+	now       func() time.Time
+}
+
+// NewDifficultyController creates a controller with no recorded requests
+func NewDifficultyController() *DifficultyController {
+	return &DifficultyController{
+		clients: make(map[string]*rateWindow),
+		now:     time.Now,
+	}
+}
+
+// difficultyController is shared by all handlers issuing challenges
+var difficultyController = NewDifficultyController()
+
+// RecordAttempt counts a shorten attempt from the given client IP towards its own difficulty,
+// whether or not its proof of work turns out to be valid
+func (d *DifficultyController) RecordAttempt(clientIP string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	now := d.now()
+
+	// Forget clients that have been quiet for a whole window
+	if now.Sub(d.lastPrune) >= rateBuckets*time.Minute {
+		d.prune(now)
+	}
+
+	window, ok := d.clients[clientIP]
+	if !ok {
+		if len(d.clients) >= rateMaxClients {
+			d.prune(now)
+		}
+		if len(d.clients) >= rateMaxClients {
+			d.evictLeastRecent()
+		}
+		window = &rateWindow{}
+		d.clients[clientIP] = window
+	}
+	window.add(now)
+}
+
+// RecordShorten counts a shorten request with a valid proof of work towards the difficulty
+// for everyone. Only verified requests count, so nobody can raise it for free.
+func (d *DifficultyController) RecordShorten() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.global.add(d.now())
+}
+
+// prune forgets clients without requests in the window, with the lock held
+func (d *DifficultyController) prune(now time.Time) {
+	for ip, w := range d.clients {
+		if w.count(now) == 0 {
+			delete(d.clients, ip)
+		}
+	}
+	d.lastPrune = now
+}
+
+// evictLeastRecent forgets the client seen least recently, with the lock held
+func (d *DifficultyController) evictLeastRecent() {
+	var oldestIP string
+	var oldest *rateWindow
+	for ip, w := range d.clients {
+		if oldest == nil || w.lastMinute() < oldest.lastMinute() {
+			oldestIP, oldest = ip, w
+		}
+	}
+	delete(d.clients, oldestIP)
+}
+
+// Difficulty returns the difficulty to issue to the given client with profile.
+// It starts at the profile difficulty when things are quiet and goes up one level
+// for every rateGlobalStep requests overall and every rateClientStep requests from
+// the client in the window, up to the profile maximum.
+func (d *DifficultyController) Difficulty(profile PoWProfile, clientIP string) int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	now := d.now()
+	difficulty := profile.Difficulty + d.global.count(now)/rateGlobalStep
+	if window, ok := d.clients[clientIP]; ok {
+		difficulty += window.count(now) / rateClientStep
+	}
+
+	if difficulty > profile.MaxDifficulty {
+		difficulty = profile.MaxDifficulty
+	}
+	return difficulty
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDifficultyController(t *testing.T) {
+	now := time.Date(2001, 9, 1, 12, 0, 0, 0, time.UTC)
+	d := NewDifficultyController()
+	d.now = func() time.Time { return now }
+
+	if got := d.Difficulty(WMLProfile, "10.0.0.1"); got != WMLProfile.Difficulty {
+		t.Errorf("quiet difficulty = %d, expected %d", got, WMLProfile.Difficulty)
+	}
+
+	// A single busy client only makes its own challenges harder
+	for i := 0; i < rateClientStep; i++ {
+		d.RecordAttempt("10.0.0.1")
+	}
+	if got := d.Difficulty(WMLProfile, "10.0.0.1"); got != WMLProfile.Difficulty+1 {
+		t.Errorf("busy client difficulty = %d, expected %d", got, WMLProfile.Difficulty+1)
+	}
+	if got := d.Difficulty(WMLProfile, "10.0.0.2"); got != WMLProfile.Difficulty {
+		t.Errorf("other client difficulty = %d, expected %d", got, WMLProfile.Difficulty)
+	}
+
+	// A spam wave of solved challenges raises difficulty for everyone, up to the maximum
+	for i := 0; i < 10*rateGlobalStep; i++ {
+		d.RecordShorten()
+	}
+	if got := d.Difficulty(WMLProfile, "10.0.0.2"); got != WMLProfile.MaxDifficulty {
+		t.Errorf("difficulty under load = %d, expected the maximum %d", got, WMLProfile.MaxDifficulty)
+	}
+
+	// Once the window has passed things are quiet again
+	now = now.Add(rateBuckets * time.Minute)
+	if got := d.Difficulty(WMLProfile, "10.0.0.1"); got != WMLProfile.Difficulty {
+		t.Errorf("difficulty after the window = %d, expected %d", got, WMLProfile.Difficulty)
+	}
+	d.RecordAttempt("10.0.0.3")
+	if len(d.clients) != 1 {
+		t.Errorf("%d clients tracked after the window, expected only the new one", len(d.clients))
+	}
+}
+
+func TestDifficultyControllerClientLimit(t *testing.T) {
+	now := time.Date(2001, 9, 1, 12, 0, 0, 0, time.UTC)
+	d := NewDifficultyController()
+	d.now = func() time.Time { return now }
+
+	for i := 0; i < rateClientStep; i++ {
+		d.RecordAttempt("10.0.0.1")
+	}
+	// Rotating addresses fills the map up to its limit, evicting the quietest clients first
+	now = now.Add(time.Minute)
+	for i := 0; i < rateMaxClients+10; i++ {
+		d.RecordAttempt(fmt.Sprintf("rotated-%d", i))
+	}
+	if len(d.clients) > rateMaxClients {
+		t.Errorf("%d clients tracked, expected at most %d", len(d.clients), rateMaxClients)
+	}
+	if _, ok := d.clients["10.0.0.1"]; ok {
+		t.Errorf("least recently seen client was not evicted")
+	}
+}
+
+func TestUnverifiedAttemptsKeepGlobalDifficulty(t *testing.T) {
+	e := newTestServer(t)
+
+	// Empty posts from rotating addresses never solve a challenge
+	for i := 0; i < 2*rateGlobalStep; i++ {
+		req := httptest.NewRequest(http.MethodPost, "/shorten.html", strings.NewReader(url.Values{"fullURL": {"http://example.com"}}.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.RemoteAddr = fmt.Sprintf("198.51.100.%d:1234", i%250+1)
+		e.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	if got := difficultyController.Difficulty(HTMLProfile, "203.0.113.1"); got != HTMLProfile.Difficulty {
+		t.Errorf("difficulty for other clients = %d after unverified attempts, expected %d", got, HTMLProfile.Difficulty)
+	}
+	if got := difficultyController.Difficulty(HTMLProfile, "198.51.100.1"); got != HTMLProfile.Difficulty {
+		t.Errorf("difficulty for an address with one attempt = %d, expected %d", got, HTMLProfile.Difficulty)
+	}
+}
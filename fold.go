@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"log"
+	"strings"
+)
+
+// fuzzyPaths enables looking up short links by their folded path, see foldPath
+var fuzzyPaths bool
+
+// pathFolder maps characters that are easily mixed up when reading a link off paper or SMS onto one of them
+var pathFolder = strings.NewReplacer("0", "o", "1", "l", "i", "l")
+
+// foldPath returns the canonical form of a path: lowercased, with 0 read as o and 1 and i read as l.
+// With fuzzyPaths on, no two live links share a folded path.
+func foldPath(path string) string {
+	return pathFolder.Replace(strings.ToLower(path))
+}
+
+// claimPathFold reserves the folded form of a newly stored link for it.
+// Returns false if the path is too similar to another link, which then keeps it.
+func claimPathFold(ctx context.Context, path string, link Link) (bool, error) {
+	if !fuzzyPaths {
+		return true, nil
+	}
+
+	owner, err := linkStore.ClaimPathFold(ctx, foldPath(path), path, link.ExpiresAt)
+	if err != nil {
+		return false, err
+	}
+	if owner == path {
+		return true, nil
+	}
+
+	// A folded path can outlive a link that was removed without releasing it, take it over then
+	if _, exists, err := linkStore.GetLink(ctx, owner); err != nil || exists {
+		return false, err
+	}
+	if err := linkStore.ReleasePathFold(ctx, foldPath(path), owner); err != nil {
+		return false, err
+	}
+	owner, err = linkStore.ClaimPathFold(ctx, foldPath(path), path, link.ExpiresAt)
+	return owner == path, err
+}
+
+// releasePathFold frees the folded form of a removed link
+func releasePathFold(ctx context.Context, path string) error {
+	if !fuzzyPaths {
+		return nil
+	}
+	return linkStore.ReleasePathFold(ctx, foldPath(path), path)
+}
+
+// lookupLink finds the link at path. When there is no exact match and fuzzyPaths is on,
+// it falls back to the link owning the folded path. Returns the link, its stored path and whether it was found.
+// The stored path is path itself when no link is found or the lookup fails, so callers can log it.
+func lookupLink(ctx context.Context, path string) (Link, string, bool, error) {
+	link, exists, err := linkStore.GetLink(ctx, path)
+	if err != nil || exists || !fuzzyPaths {
+		return link, path, exists, err
+	}
+
+	owner, exists, err := linkStore.GetPathFold(ctx, foldPath(path))
+	if err != nil || !exists {
+		return Link{}, path, false, err
+	}
+
+	// Ambiguity guard: only follow an owner that really folds to the same form and still exists.
+	// Links created before fuzzyPaths was on are not in the index and only match exactly.
+	if foldPath(owner) != foldPath(path) {
+		log.Printf("Folded path index for %s points at unrelated path %s", path, owner)
+		return Link{}, path, false, nil
+	}
+	link, exists, err = linkStore.GetLink(ctx, owner)
+	if err != nil || !exists {
+		return Link{}, path, false, err
+	}
+	return link, owner, true, nil
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestFoldPath(t *testing.T) {
+	tests := map[string]string{
+		"abc":    "abc",
+		"ABC":    "abc",
+		"B0ld":   "bold",
+		"Bo1d":   "bold",
+		"BOID":   "bold",
+		"x_y-Z9": "x_y-z9",
+	}
+	for path, want := range tests {
+		if got := foldPath(path); got != want {
+			t.Errorf("foldPath(%s) = %s, expected %s", path, got, want)
+		}
+	}
+}
+
+func TestFuzzyPaths(t *testing.T) {
+	e := newTestServer(t)
+	fuzzyPaths = true
+	t.Cleanup(func() { fuzzyPaths = false })
+
+	create := func(path string) apiError {
+		challenge, solution := fetchSolvedChallenge(t, e)
+		body, _ := json.Marshal(map[string]interface{}{
+			"url":           "http://example.com/" + path,
+			"path":          path,
+			"pow_challenge": challenge,
+			"pow_solution":  solution,
+		})
+		var response apiError
+		doJSON(t, e, http.MethodPost, "/api/v1/links", string(body), &response)
+		return response
+	}
+
+	if response := create("Bo1d"); response.Error.Code != "" {
+		t.Fatalf("creating Bo1d failed: %s", response.Error.Message)
+	}
+	if response := create("BOLD"); response.Error.Code != "path_taken" {
+		t.Errorf("creating BOLD = %q, expected path_taken", response.Error.Code)
+	}
+	if _, exists, _ := linkStore.GetLink(context.Background(), "BOLD"); exists {
+		t.Errorf("the rejected link BOLD was kept")
+	}
+
+	// A link that doesn't fold like its index entry is never followed
+	storeTestLink(t, linkStore, "other", Link{URL: "http://example.org"})
+	if _, err := linkStore.ClaimPathFold(context.Background(), "stray", "other", time.Time{}); err != nil {
+		t.Fatalf("ClaimPathFold failed: %v", err)
+	}
+
+	for _, tt := range []struct {
+		target   string
+		location string
+	}{
+		{"/Bo1d", "http://example.com/Bo1d"},
+		{"/b0ld", "http://example.com/Bo1d"},
+		{"/BOID", "http://example.com/Bo1d"},
+		{"/stray", ""},
+		{"/bolt", ""},
+	} {
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, tt.target, nil))
+		if rec.Header().Get("Location") != tt.location {
+			t.Errorf("GET %s redirected to %q, expected %q", tt.target, rec.Header().Get("Location"), tt.location)
+		}
+	}
+	// Paths without a link resolve to themselves, so failed lookups log what was asked for
+	for _, path := range []string{"stray", "bolt"} {
+		if _, resolved, exists, err := lookupLink(context.Background(), path); exists || resolved != path || err != nil {
+			t.Errorf("lookupLink(%s) = %q, %t, %v, expected %q", path, resolved, exists, err, path)
+		}
+	}
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// LinkLifetime is a lifetime users can pick for a short link
+type LinkLifetime struct {
+	Name     string        // value used by the form and API, e.g. "1d"
+	Label    string        // human readable label shown in the form
+	Duration time.Duration // 0 means the link never expires
+}
+
+// knownLinkLifetimes lists every lifetime the server supports
+var knownLinkLifetimes = []LinkLifetime{
+	{Name: "1h", Label: "1 hour", Duration: time.Hour},
+	{Name: "1d", Label: "1 day", Duration: 24 * time.Hour},
+	{Name: "1w", Label: "1 week", Duration: 7 * 24 * time.Hour},
+	{Name: "30d", Label: "30 days", Duration: 30 * 24 * time.Hour},
+	{Name: "permanent", Label: "Forever", Duration: 0},
+}
+
+// defaultLinkLifetimes are the lifetimes offered when LINK_LIFETIMES is not set
+const defaultLinkLifetimes = "1h,1d,1w,30d"
+
+// defaultLinkLifetime is preselected in the form and used when a request doesn't pick a lifetime
+const defaultLinkLifetime = "1d"
+
+// linkLifetimes holds the lifetimes offered by this server, in the order of the form
+var linkLifetimes, _ = parseLinkLifetimes(defaultLinkLifetimes)
+
+// parseLinkLifetimes parses a comma separated list of lifetime names, e.g. "1d,1w,permanent"
+func parseLinkLifetimes(names string) ([]LinkLifetime, error) {
+	if strings.TrimSpace(names) == "" {
+		names = defaultLinkLifetimes
+	}
+
+	var lifetimes []LinkLifetime
+	seen := make(map[string]bool)
+	for _, name := range strings.Split(names, ",") {
+		name = strings.TrimSpace(name)
+		if seen[name] {
+			return nil, fmt.Errorf("link lifetime %q is listed twice", name)
+		}
+		seen[name] = true
+		found := false
+		for _, lifetime := range knownLinkLifetimes {
+			if lifetime.Name == name {
+				lifetimes = append(lifetimes, lifetime)
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("unknown link lifetime %q", name)
+		}
+	}
+
+	return lifetimes, nil
+}
+
+// findLinkLifetime looks up an offered lifetime by name, falling back to the default when name is empty
+func findLinkLifetime(name string) (LinkLifetime, bool) {
+	if name == "" {
+		return defaultLifetime(), true
+	}
+	for _, lifetime := range linkLifetimes {
+		if lifetime.Name == name {
+			return lifetime, true
+		}
+	}
+	return LinkLifetime{}, false
+}
+
+// defaultLifetime returns the default lifetime, or the first offered one if the default is disabled
+func defaultLifetime() LinkLifetime {
+	for _, lifetime := range linkLifetimes {
+		if lifetime.Name == defaultLinkLifetime {
+			return lifetime
+		}
+	}
+	return linkLifetimes[0]
+}
+
+// ExpiresAt returns when a link created at now expires, or the zero time if it never does
+func (l LinkLifetime) ExpiresAt(now time.Time) time.Time {
+	if l.Duration == 0 {
+		return time.Time{}
+	}
+	return now.Add(l.Duration)
+}
+
+// formatExpiry describes an expiry time for humans
+func formatExpiry(expiresAt time.Time) string {
+	if expiresAt.IsZero() {
+		return "never expires"
+	}
+	return "expires " + expiresAt.UTC().Format("2006-01-02 15:04 MST")
+}
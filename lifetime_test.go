@@ -0,0 +1,129 @@
+package main
+
+import (
+	"context"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// lifetimeNames lists the names of lifetimes, in order
+func lifetimeNames(lifetimes []LinkLifetime) string {
+	var names []string
+	for _, lifetime := range lifetimes {
+		names = append(names, lifetime.Name)
+	}
+	return strings.Join(names, ",")
+}
+
+func TestParseLinkLifetimes(t *testing.T) {
+	tests := []struct {
+		list     string
+		expected string // names of the parsed lifetimes, empty if the list is invalid
+	}{
+		{"", defaultLinkLifetimes},
+		{"  ", defaultLinkLifetimes},
+		{"1d", "1d"},
+		{"permanent, 1h,30d", "permanent,1h,30d"},
+		{"1h,1d,1w,30d,permanent", "1h,1d,1w,30d,permanent"},
+		{"2d", ""},
+		{"1d,forever", ""},
+		{"1D", ""},
+		{"1d,,1w", ""},
+		{"1d,1w,1d", ""},
+		{"1h, 1h", ""},
+	}
+	for _, tt := range tests {
+		lifetimes, err := parseLinkLifetimes(tt.list)
+		if tt.expected == "" {
+			if err == nil {
+				t.Errorf("parseLinkLifetimes(%q) = %s, expected an error", tt.list, lifetimeNames(lifetimes))
+			}
+			continue
+		}
+		if err != nil || lifetimeNames(lifetimes) != tt.expected {
+			t.Errorf("parseLinkLifetimes(%q) = %s, %v, expected %s", tt.list, lifetimeNames(lifetimes), err, tt.expected)
+		}
+	}
+}
+
+func TestFindLinkLifetime(t *testing.T) {
+	oldLifetimes := linkLifetimes
+	t.Cleanup(func() { linkLifetimes = oldLifetimes })
+
+	tests := []struct {
+		offered  string
+		name     string
+		expected string // name of the lifetime found, empty if none is
+	}{
+		{"1h,1d,1w", "1w", "1w"},
+		{"1h,1d,1w", "", "1d"},
+		{"1h,1d,1w", "30d", ""},
+		{"1h,1d,1w", "1W", ""},
+		{"1w,permanent", "", "1w"}, // the default is not offered, the first lifetime is
+		{"1w,permanent", "1d", ""},
+		{"1w,permanent", "permanent", "permanent"},
+	}
+	for _, tt := range tests {
+		linkLifetimes, _ = parseLinkLifetimes(tt.offered)
+		lifetime, ok := findLinkLifetime(tt.name)
+		if ok != (tt.expected != "") || lifetime.Name != tt.expected {
+			t.Errorf("findLinkLifetime(%q) offering %s = %q, %t, expected %q", tt.name, tt.offered, lifetime.Name, ok, tt.expected)
+		}
+	}
+}
+
+func TestShortenStoresLifetime(t *testing.T) {
+	e := newTestServer(t)
+	oldLifetimes := linkLifetimes
+	linkLifetimes, _ = parseLinkLifetimes("1h,1d,permanent")
+	t.Cleanup(func() { linkLifetimes = oldLifetimes })
+
+	tests := []struct {
+		lifetime string
+		duration time.Duration // 0 for links that never expire
+		created  bool
+	}{
+		{"1h", time.Hour, true},
+		{"", 24 * time.Hour, true},
+		{"permanent", 0, true},
+		{"1w", 0, false}, // known, but not offered here
+	}
+	for i, tt := range tests {
+		path := "lifetime" + strconv.Itoa(i)
+		challenge, solution := fetchSolvedChallenge(t, e)
+		before := time.Now()
+		rec := doForm(e, "/shorten.html", url.Values{
+			"fullURL":       {"http://example.com"},
+			"path":          {path},
+			"lifetime":      {tt.lifetime},
+			"pow_challenge": {challenge},
+			"pow_solution":  {strconv.Itoa(solution)},
+		})
+		after := time.Now()
+
+		link, exists, err := linkStore.GetLink(context.Background(), path)
+		if err != nil || exists != tt.created {
+			t.Fatalf("lifetime %q: GetLink = %t, %v, expected stored %t", tt.lifetime, exists, err, tt.created)
+		}
+		if !tt.created {
+			if !strings.Contains(rec.Body.String(), "invalid lifetime") {
+				t.Errorf("lifetime %q was not rejected:\n%s", tt.lifetime, rec.Body.String())
+			}
+			continue
+		}
+
+		if tt.duration == 0 {
+			if !link.ExpiresAt.IsZero() {
+				t.Errorf("lifetime %q stored ExpiresAt %v, expected never", tt.lifetime, link.ExpiresAt)
+			}
+		} else if link.ExpiresAt.Before(before.Add(tt.duration)) || link.ExpiresAt.After(after.Add(tt.duration)) {
+			t.Errorf("lifetime %q stored ExpiresAt %v, expected %v from now", tt.lifetime, link.ExpiresAt, tt.duration)
+		}
+		if expiry := formatExpiry(link.ExpiresAt); !strings.Contains(rec.Body.String(), expiry) {
+			t.Errorf("lifetime %q: success page does not say %q:\n%s", tt.lifetime, expiry, rec.Body.String())
+		}
+	}
+}
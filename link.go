@@ -0,0 +1,80 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// linkVersion is the version of the link format written by encodeLink
+const linkVersion = 1
+
+// Link is a short link as kept in storage
+type Link struct {
+	URL         string    `json:"url"`
+	CreatedAt   time.Time `json:"created_at"`             // zero for links stored before creation times were kept
+	ExpiresAt   time.Time `json:"expires_at"`             // zero if the link never expires
+	OwnerHash   string    `json:"owner_hash,omitempty"`   // hash of the management token, empty if the link can't be managed
+	CreatorHash string    `json:"creator_hash,omitempty"` // keyed hash of the IP the link was created from
+	Client      string    `json:"client,omitempty"`       // class of the client that created the link: ClientWAP, ClientHTML or ClientAPI
+	Difficulty  int       `json:"difficulty,omitempty"`   // proof of work difficulty solved to create the link
+	Disabled    bool      `json:"disabled,omitempty"`     // disabled links are kept but no longer redirect
+}
+
+// storedLink is the JSON format of links in storage, tagged with its version
+type storedLink struct {
+	Version int `json:"v"`
+	Link
+}
+
+// encodeLink serializes a link for storage backends that keep strings
+func encodeLink(link Link) (string, error) {
+	buf, err := json.Marshal(storedLink{Version: linkVersion, Link: link})
+	if err != nil {
+		return "", err
+	}
+	return string(buf), nil
+}
+
+// decodeLink parses a stored link. Values stored before links were JSON
+// encoded are the plain destination URL and are read as such, JSON without
+// a version has the fields of version 1.
+func decodeLink(value string) (Link, error) {
+	if isLegacyLinkValue(value) {
+		return Link{URL: value}, nil
+	}
+
+	var stored storedLink
+	if err := json.Unmarshal([]byte(value), &stored); err != nil {
+		return Link{}, err
+	}
+	if stored.Version > linkVersion {
+		return Link{}, fmt.Errorf("unsupported link version %d", stored.Version)
+	}
+	return stored.Link, nil
+}
+
+// isLegacyLinkValue reports whether a stored link is a plain destination URL from
+// before links were JSON encoded. Only its storage knows when it expires.
+func isLegacyLinkValue(value string) bool {
+	return !strings.HasPrefix(value, "{")
+}
+
+// hashCreatorIP hashes the IP a link was created from, so links from one client can be
+// found without keeping its address. The hash is keyed with the challenge secret, set
+// POW_SECRET to keep it stable across restarts.
+func hashCreatorIP(clientIP string) string {
+	mac := hmac.New(sha256.New, challengeSigner.secret)
+	mac.Write([]byte("creator-ip:" + clientIP))
+	return hex.EncodeToString(mac.Sum(nil)[:16])
+}
+
+// isDisabled reports whether a link no longer redirects, because it was disabled
+// or its destination was blocked after it was created
+func isDisabled(link Link) bool {
+	return link.Disabled || blocklist.Blocks(link.URL)
+}
@@ -1,16 +1,18 @@
 package main
 
 import (
+	"context"
 	"crypto/rand"
+	"errors"
 	"html/template"
 	"log"
 	"net/http"
 	"net/url"
 	"os"
 	"path/filepath"
-	"regexp"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/labstack/echo/v4"
 )
@@ -18,25 +20,160 @@ import (
 // TemplateData holds data for rendering the index template
 type TemplateData struct {
 	PoWChallenge   string
+	PoWDifficulty  int
 	FullURL        string
 	Path           string
+	Lifetime       string
+	Lifetimes      []LinkLifetime
+	SlugStyle      string
+	SlugStyles     []SlugStyle
 	ErrorMessage   string
 	SuccessMessage string
+	ManagePath     string // secret management page of a link that was just created
+	PathPolicy     PathPolicy
 }
 
-var challengeStore ChallengeStorage
+// PoWMaxIterations is how many solutions the client tries for the challenge
+func (d TemplateData) PoWMaxIterations() int {
+	return powMaxIterations(d.PoWDifficulty)
+}
+
+// shortURLPrefix is prepended to paths when showing short URLs to users
+const shortURLPrefix = "wap.fyi/"
+
+// ShortenRequest holds the fields submitted to create a short link
+type ShortenRequest struct {
+	FullURL   string
+	Path      string
+	Lifetime  string
+	SlugStyle string // style of the random path, only used when Path is empty
+	Client    string // class of the client sending the request
+	Challenge string
+	Solution  string
+}
+
+// ShortLink describes a short link that was just created
+type ShortLink struct {
+	Link
+	Path        string
+	Lifetime    LinkLifetime
+	ManageToken string // only handed out once, storage keeps its hash
+}
+
+// shortenError is a rejected shorten request, with a stable code for the API
+// and a message for the forms
+type shortenError struct {
+	Code    string
+	Message string
+}
+
+func (e *shortenError) Error() string {
+	return e.Message
+}
+
+var challengeStore ChallengeStore
+var linkStore LinkStore
+var challengeSigner *ChallengeSigner
 
 func main() {
-	// Initialize challenge storage
-	challengeStore = NewChallengeStorage()
-	defer challengeStore.Close()
+	// Bound how long requests wait for storage
+	if timeout := os.Getenv("STORAGE_TIMEOUT"); timeout != "" {
+		var err error
+		storageTimeout, err = time.ParseDuration(timeout)
+		if err != nil || storageTimeout <= 0 {
+			log.Fatalf("Invalid STORAGE_TIMEOUT %q, expected a duration like 2s", timeout)
+		}
+	}
+
+	// Initialize challenge and link storage
+	storage, err := NewStorage()
+	if err != nil {
+		log.Fatalf("Failed to initialize storage: %v", err)
+	}
+	defer storage.Close()
+	challengeStore, linkStore = storage.Challenges, storage.Links
 
+	// Initialize the link lifetimes offered in the form
+	linkLifetimes, err = parseLinkLifetimes(os.Getenv("LINK_LIFETIMES"))
+	if err != nil {
+		log.Fatalf("Invalid LINK_LIFETIMES: %v", err)
+	}
+
+	// Optionally match paths regardless of case and 0/O and 1/I/L mixups
+	fuzzyPaths = os.Getenv("FUZZY_PATHS") == "true"
+
+	// Limit the length of short paths
+	pathPolicy, err = parsePathPolicy(os.Getenv("PATH_MIN_LENGTH"), os.Getenv("PATH_MAX_LENGTH"))
+	if err != nil {
+		log.Fatalf("Invalid path lengths: %v", err)
+	}
+
+	// Reserve the names of our static files and pages
+	reservedPaths, err = loadReservedPaths("templates", os.Getenv("RESERVED_PATHS"))
+	if err != nil {
+		log.Fatalf("Failed to load reserved paths: %v", err)
+	}
+
+	// Only believe X-Forwarded-For from our own reverse proxies
+	trustedProxies, err = parseTrustedProxies(os.Getenv("TRUSTED_PROXIES"))
+	if err != nil {
+		log.Fatalf("Invalid TRUSTED_PROXIES: %v", err)
+	}
+
+	// Destinations on our own hosts are followed to catch loops, other shorteners count towards
+	// the chain length and are only refused outright when asked to
+	if hosts := os.Getenv("PUBLIC_HOSTS"); hosts != "" {
+		publicHosts = parseHostList(hosts)
+	}
+	otherShorteners = parseHostList(os.Getenv("OTHER_SHORTENERS"))
+	refuseOtherShorteners = os.Getenv("REFUSE_OTHER_SHORTENERS") == "true"
+
+	// Load the blocklist, send SIGHUP to pick up changes to the file
+	if blocklistFile := os.Getenv("BLOCKLIST_FILE"); blocklistFile != "" {
+		blocklist = NewBlocklist(blocklistFile)
+		if err := blocklist.Reload(); err != nil {
+			log.Fatalf("Failed to load blocklist: %v", err)
+		}
+		blocklist.ReloadOnSIGHUP()
+	}
+
+	// Record clicks in the background, stats are shown to holders of STATS_TOKEN
+	clickRecorder = NewClickRecorder(linkStore)
+	defer clickRecorder.Close()
+	statsToken = os.Getenv("STATS_TOKEN")
+
+	// Initialize challenge signing, challenges only survive restarts with a configured secret
+	if secret := os.Getenv("POW_SECRET"); secret != "" {
+		challengeSigner = NewChallengeSigner([]byte(secret), challengeTTL)
+	} else {
+		challengeSigner, err = NewRandomChallengeSigner(challengeTTL)
+		if err != nil {
+			log.Fatalf("Failed to generate challenge secret: %v", err)
+		}
+		log.Println("POW_SECRET is not set, using a random secret for this run")
+	}
+
+	e := newServer()
+	e.Start(":8080")
+}
+
+// newServer creates the echo instance with all routes registered
+func newServer() *echo.Echo {
 	e := echo.New()
+	e.IPExtractor = clientIPExtractor(trustedProxies)
 	e.GET("/", serveHome)
 	e.POST("/shorten.html", handleShorten)
 	e.GET("/shorten.html", serveHome)
+	e.POST("/shorten.wml", handleShortenWML)
+	e.GET("/shorten.wml", serveHome)
+	e.GET("/api/v1/challenge", handleAPIChallenge)
+	e.POST("/api/v1/links", handleAPICreateLink)
+	e.GET("/api/v1/links/:path", handleAPIGetLink)
+	e.GET("/stats/:path", handleStats, keepTokenPrivate)
+	e.GET("/manage/:path", handleManage, keepTokenPrivate)
+	e.POST("/manage/:path", handleManagePost, keepTokenPrivate)
 	e.GET("/*", handleRedirectOrStatic)
-	e.Start(":8080")
+	return e
 }
 
 // generateRandomString generates a random string of specified length using [a-zA-Z0-9] characters
@@ -80,110 +217,108 @@ func renderIndexWithData(c echo.Context, data TemplateData) error {
 	return tmpl.Execute(c.Response().Writer, data)
 }
 
-// generateNewChallenge generates a new unique challenge and stores it
-func generateNewChallenge() (string, error) {
-	var challenge string
-	var err error
-	maxTries := 1000
-	for i := 0; i < maxTries; i++ {
-		challenge, err = generateRandomString(200)
-		if err != nil {
-			return "", err
-		}
-		if _, exists, err := challengeStore.Get(challenge); err != nil {
-			return "", err
-		} else if exists {
-			continue // If challenge already exists, generate a new one
-		}
-		break // Exit loop if a unique challenge is generated
-	}
-
-	// Store the challenge as unsolved initially
-	err = challengeStore.Store(challenge, false)
-	if err != nil {
-		return "", err
-	}
-
-	return challenge, nil
+// generateNewChallenge generates a new signed challenge for the given profile and client,
+// returning the challenge and the difficulty picked for it
+func generateNewChallenge(profile PoWProfile, clientIP string) (string, int, error) {
+	difficulty := difficultyController.Difficulty(profile, clientIP)
+	challenge, err := challengeSigner.Issue(difficulty)
+	return challenge, difficulty, err
 }
 
 func serveHome(c echo.Context) error {
-	challenge, err := generateNewChallenge()
+	// Check if the client accepts WAP content
+	profile := HTMLProfile
+	if acceptsWML(c) {
+		profile = WMLProfile
+	}
+
+	challenge, difficulty, err := generateNewChallenge(profile, c.RealIP())
 	if err != nil {
 		log.Printf("Failed to generate challenge: %v", err)
 		return c.String(http.StatusInternalServerError, "error generating challenge")
 	}
 
-	acceptHeader := c.Request().Header.Get("Accept")
-
-	// Check if the client accepts WAP content
-	if strings.Contains(acceptHeader, "text/vnd.wap.wml") {
-		// Wap device detected, redirect to Bevelgacom WAP site
-		return c.Redirect(http.StatusMovedPermanently, "https://wap.bevelgacom.be")
-	}
-
 	data := TemplateData{
 		PoWChallenge:   challenge,
+		PoWDifficulty:  difficulty,
 		FullURL:        "",
 		Path:           "",
+		Lifetime:       defaultLifetime().Name,
+		Lifetimes:      linkLifetimes,
+		SlugStyle:      slugStyles[0].Name,
+		SlugStyles:     slugStyles,
 		ErrorMessage:   "",
 		SuccessMessage: "",
+		PathPolicy:     pathPolicy,
+	}
+
+	if profile == WMLProfile {
+		// Wap device detected, serve the WML deck
+		return renderIndexWML(c, data)
 	}
 
 	return renderIndexWithData(c, data)
 }
 
-func verifyChallenge(c echo.Context) (bool, string, error) {
-	challenge := c.FormValue("pow_challenge")
-	solution := c.FormValue("pow_solution")
-
+// verifyChallenge checks a proof of work solution, marks its challenge as spent and returns it.
+// Returns a *shortenError if the solution is rejected, or any other error on internal failures.
+func verifyChallenge(ctx context.Context, challenge, solution string) (Challenge, error) {
 	if challenge == "" || solution == "" {
-		return false, "challenge and solution are required", nil
+		return Challenge{}, &shortenError{Code: "challenge_required", Message: "challenge and solution are required"}
 	}
 
 	// Convert solution to integer
 	solutionInt, err := strconv.Atoi(solution)
 	if err != nil {
-		return false, "invalid solution format", nil
+		return Challenge{}, &shortenError{Code: "invalid_solution", Message: "invalid solution format"}
 	}
 
-	// check if the challenge exists and is unsolved
-	solved, exists, err := challengeStore.Get(challenge)
-	if err != nil {
-		log.Printf("Failed to retrieve challenge: %v", err)
-		return false, "", err // Return actual error for internal server errors
+	// Check the signature and age of the challenge, this needs no storage lookup
+	parsed, err := challengeSigner.Verify(challenge)
+	if err == errChallengeExpired {
+		return Challenge{}, &shortenError{Code: "challenge_expired", Message: err.Error()}
 	}
-	if !exists {
-		return false, "challenge not found", nil
-	}
-	if solved {
-		return false, "challenge already solved", nil
+	if err != nil {
+		return Challenge{}, &shortenError{Code: "invalid_challenge", Message: err.Error()}
 	}
 
-	// Verify the proof of work
-	if !VerifyProofOfWork(challenge, solutionInt, 4) {
-		return false, "invalid proof of work", nil
+	// Verify the proof of work with the difficulty signed into the challenge
+	if !VerifyProofOfWork(challenge, solutionInt, parsed.Difficulty) {
+		return Challenge{}, &shortenError{Code: "invalid_proof_of_work", Message: "invalid proof of work"}
 	}
 
-	// Mark the challenge as solved
-	err = challengeStore.Store(challenge, true)
+	// Mark the challenge as spent, atomically so it can only be used once
+	marked, err := challengeStore.MarkSpent(ctx, parsed.ID, challengeTTL)
 	if err != nil {
-		log.Printf("Failed to mark challenge as solved: %v", err)
-		return false, "", err // Return actual error for internal server errors
+		log.Printf("Failed to mark challenge as spent: %v", err)
+		return Challenge{}, err
+	}
+	if !marked {
+		return Challenge{}, &shortenError{Code: "challenge_spent", Message: "challenge already solved"}
 	}
 
-	return true, "", nil
+	return parsed, nil
+}
+
+// serveDisabled serves the page for links whose destination has been blocked
+func serveDisabled(c echo.Context) error {
+	if acceptsWML(c) {
+		// Like the 404 deck, use a 200 status so handsets show the deck instead of their own error
+		return renderWML(c, http.StatusOK, "disabled.wml", nil)
+	}
+
+	tmpl := template.Must(template.ParseFiles("./templates/disabled.html"))
+	c.Response().Header().Set("Content-Type", "text/html")
+	c.Response().WriteHeader(http.StatusGone)
+	return tmpl.Execute(c.Response().Writer, nil)
 }
 
 // serve404 serves the appropriate 404 page based on the Accept header
 func serve404(c echo.Context) error {
-	acceptHeader := c.Request().Header.Get("Accept")
-
 	// Check if the client accepts WAP content
-	if strings.Contains(acceptHeader, "text/vnd.wap.wml") {
-		// Serve WAP 404 page
-		c.Response().Header().Set("Content-Type", "text/vnd.wap.wml")
-		return c.File("templates/404.wml")
+	if acceptsWML(c) {
+		// Serve WAP 404 page with a 200 status so handsets show the deck instead of their own error
+		return renderWML(c, http.StatusOK, "404.wml", nil)
 	}
 
 	// Serve regular 404 response
@@ -191,123 +326,269 @@ func serve404(c echo.Context) error {
 }
 
 func handleShorten(c echo.Context) error {
-	fullURL := c.FormValue("fullURL")
-	path := c.FormValue("path")
+	return shorten(c, HTMLProfile, renderIndexWithData)
+}
 
-	// Helper function to render error with form values preserved
-	renderError := func(errorMsg string) error {
-		challenge, err := generateNewChallenge()
-		if err != nil {
-			log.Printf("Failed to generate new challenge: %v", err)
-			return c.String(http.StatusInternalServerError, "error generating new challenge")
-		}
+// handleShortenWML handles shorten requests posted from the WML deck
+func handleShortenWML(c echo.Context) error {
+	return shorten(c, WMLProfile, renderIndexWML)
+}
+
+// shorten handles a shorten form post, rendering the outcome with the given page renderer
+// and issuing follow-up challenges for profile
+func shorten(c echo.Context, profile PoWProfile, render func(echo.Context, TemplateData) error) error {
+	req := ShortenRequest{
+		FullURL:   c.FormValue("fullURL"),
+		Path:      c.FormValue("path"),
+		Lifetime:  c.FormValue("lifetime"),
+		SlugStyle: c.FormValue("slug_style"),
+		Challenge: c.FormValue("pow_challenge"),
+		Solution:  c.FormValue("pow_solution"),
+		Client:    ClientHTML,
+	}
+	if profile == WMLProfile {
+		req.Client = ClientWAP
+	}
 
-		data := TemplateData{
+	ctx, cancel := storageContext(c)
+	defer cancel()
+
+	link, err := createShortLink(ctx, req, c.RealIP())
+
+	var shortenErr *shortenError
+	if err != nil && !errors.As(err, &shortenErr) {
+		// Storage timed out or another internal server error
+		return serveStorageError(c, err)
+	}
+
+	// Generate a new challenge for the next attempt
+	challenge, difficulty, err := generateNewChallenge(profile, c.RealIP())
+	if err != nil {
+		log.Printf("Failed to generate new challenge: %v", err)
+		return c.String(http.StatusInternalServerError, "error generating new challenge")
+	}
+
+	if shortenErr != nil {
+		// Render the error with form values preserved
+		return render(c, TemplateData{
 			PoWChallenge:   challenge,
-			FullURL:        fullURL,
-			Path:           path,
-			ErrorMessage:   errorMsg,
+			PoWDifficulty:  difficulty,
+			FullURL:        req.FullURL,
+			Path:           req.Path,
+			Lifetime:       req.Lifetime,
+			Lifetimes:      linkLifetimes,
+			SlugStyle:      req.SlugStyle,
+			SlugStyles:     slugStyles,
+			ErrorMessage:   shortenErr.Message,
 			SuccessMessage: "",
-		}
+			PathPolicy:     pathPolicy,
+		})
+	}
+
+	// Render success page with shortened URL
+	data := TemplateData{
+		PoWChallenge:   challenge,
+		PoWDifficulty:  difficulty,
+		FullURL:        "",
+		Path:           "",
+		Lifetime:       link.Lifetime.Name,
+		Lifetimes:      linkLifetimes,
+		SlugStyle:      req.SlugStyle,
+		SlugStyles:     slugStyles,
+		ErrorMessage:   "",
+		SuccessMessage: "URL shortened successfully! Your short URL is: " + shortURLPrefix + link.Path + " (" + formatExpiry(link.ExpiresAt) + ")",
+		ManagePath:     managePath(link.Path, link.ManageToken),
+		PathPolicy:     pathPolicy,
+	}
+
+	return render(c, data)
+}
+
+// createShortLink verifies the proof of work of a shorten request and stores its URL mapping.
+// Returns a *shortenError if the request is rejected, or any other error on internal failures.
+func createShortLink(ctx context.Context, req ShortenRequest, clientIP string) (ShortLink, error) {
+	// Every attempt raises the difficulty of the client's next challenges, but only
+	// solved challenges raise it for everyone
+	difficultyController.RecordAttempt(clientIP)
 
-		return renderIndexWithData(c, data)
+	challenge, err := verifyChallenge(ctx, req.Challenge, req.Solution)
+	if err != nil {
+		return ShortLink{}, err
+	}
+	difficultyController.RecordShorten()
+
+	// If the challenge is verified, proceed with URL shortening
+	var slugStyle SlugStyle
+	if req.Path == "" {
+		// Random paths are generated in the requested style
+		var ok bool
+		slugStyle, ok = findSlugStyle(req.SlugStyle)
+		if !ok {
+			return ShortLink{}, &shortenError{Code: "invalid_slug_style", Message: "invalid random path style"}
+		}
+	} else if err := checkCustomPath(req.Path); err != nil {
+		return ShortLink{}, err
 	}
 
-	ok, errorMsg, err := verifyChallenge(c)
+	fullURL, err := validateFullURL(req.FullURL)
 	if err != nil {
-		// Internal server error
-		return c.String(http.StatusInternalServerError, "internal server error")
+		return ShortLink{}, err
 	}
+
+	lifetime, ok := findLinkLifetime(req.Lifetime)
 	if !ok {
-		return renderError(errorMsg)
+		return ShortLink{}, &shortenError{Code: "invalid_lifetime", Message: "invalid lifetime"}
 	}
 
-	// If the challenge is verified, proceed with URL shortening
-	if path == "" {
-		// Generate a random 5-character path with [a-z0-9]
-		var err error
-		maxTries := 1000
-		for i := 0; i < maxTries; i++ {
-			path, err = generateRandomPath(5)
+	manageToken, err := generateRandomString(manageTokenLength)
+	if err != nil {
+		log.Printf("Failed to generate management token: %v", err)
+		return ShortLink{}, err
+	}
+
+	now := time.Now()
+	link := Link{
+		URL:         fullURL,
+		CreatedAt:   now,
+		ExpiresAt:   lifetime.ExpiresAt(now),
+		OwnerHash:   hashManageToken(manageToken),
+		CreatorHash: hashCreatorIP(clientIP),
+		Client:      req.Client,
+		Difficulty:  challenge.Difficulty,
+	}
+
+	// A random path can still be taken by another request between picking and storing it,
+	// the user never chose it, so pick another one instead of failing
+	for tries := 1; ; tries++ {
+		path := req.Path
+		if path == "" {
+			path, err = pickRandomPath(ctx, slugStyle)
 			if err != nil {
-				log.Printf("Failed to generate random path: %v", err)
-				return c.String(http.StatusInternalServerError, "error generating random path")
+				return ShortLink{}, err
 			}
+		}
 
-			// Check if the generated path already exists
-			_, exists, err := challengeStore.GetURL(path)
-			if err != nil {
-				log.Printf("Failed to check if path exists: %v", err)
-				return c.String(http.StatusInternalServerError, "error checking path availability")
-			}
-			if !exists {
-				break // Path is available, use it
-			}
-			// If path exists, generate a new one
+		err = storeShortLink(ctx, path, link)
+		var shortenErr *shortenError
+		if req.Path == "" && tries < maxRandomPathTries && errors.As(err, &shortenErr) && shortenErr.Code == "path_taken" {
+			continue
+		}
+		if err != nil {
+			return ShortLink{}, err
 		}
+
+		return ShortLink{
+			Link:        link,
+			Path:        path,
+			Lifetime:    lifetime,
+			ManageToken: manageToken,
+		}, nil
 	}
+}
+
+// maxRandomPathTries is how many random paths are tried before giving up
+const maxRandomPathTries = 1000
 
-	// check if the path is [a-zA-Z0-9_-] and not too long
-	// it may not conain any characters other than [a-zA-Z0-9_-]
-	if len(path) < 1 || len(path) > 50 {
-		return renderError("invalid path length, must be between 1 and 50 characters")
+// checkCustomPath checks a path picked by the user.
+// Returns a *shortenError if it can't be used.
+func checkCustomPath(path string) error {
+	// check if the path is [a-zA-Z0-9_-] and within the length limits
+	if err := pathPolicy.Validate(path); err != nil {
+		return err
 	}
-	// check if path contains only valid characters with regexp
 
-	regexpPath := regexp.MustCompile(`^[a-zA-Z0-9_-]+$`)
-	if !regexpPath.MatchString(path) {
-		return renderError("invalid path format, must contain only [a-zA-Z0-9_-]")
+	// Paths may not shadow our static files and pages
+	if isReservedPath(path) {
+		return &shortenError{Code: "path_reserved", Message: "this path is reserved, please pick another one"}
 	}
+	return nil
+}
 
-	if fullURL == "" {
-		return renderError("full URL is required")
+// pickRandomPath generates a random path in slugStyle that no link uses yet,
+// or with fuzzyPaths no link folding the same way
+func pickRandomPath(ctx context.Context, slugStyle SlugStyle) (string, error) {
+	var path string
+	for i := 0; i < maxRandomPathTries; i++ {
+		var err error
+		path, err = slugStyle.generate()
+		if err != nil {
+			log.Printf("Failed to generate random path: %v", err)
+			return "", err
+		}
+
+		_, _, exists, err := lookupLink(ctx, path)
+		if err != nil {
+			log.Printf("Failed to check if path exists: %v", err)
+			return "", err
+		}
+		if !exists && !isReservedPath(path) {
+			return path, nil // Path is available, use it
+		}
+		// If path exists, generate a new one
 	}
-	if len(fullURL) > 200 {
-		return renderError("full URL is too long, must be less than 200 characters")
+	return "", &shortenError{Code: "path_taken", Message: "no free random path left, please pick a path"}
+}
+
+// storeShortLink stores link at path, unless the destination loops back to it or another
+// link already claimed the path. Returns a *shortenError if the link is refused.
+func storeShortLink(ctx context.Context, path string, link Link) error {
+	if err := checkLinkChain(ctx, path, link.URL); err != nil {
+		return err
 	}
 
-	// check if the path already exists
-	_, exists, err := challengeStore.GetURL(path)
+	// Store the link, unless another request claimed the path first
+	stored, err := linkStore.StoreLinkIfAbsent(ctx, path, link)
 	if err != nil {
-		log.Printf("Failed to retrieve URL mapping: %v", err)
-		return c.String(http.StatusInternalServerError, "error retrieving URL mapping")
+		log.Printf("Failed to store URL mapping: %v", err)
+		return err
 	}
-	if exists {
-		return renderError("path already exists")
+	if !stored {
+		return &shortenError{Code: "path_taken", Message: "path already exists"}
 	}
 
-	// Check if the full URL is valid, if http:// is not provided, add it
-	if !isValidURL(fullURL) {
-		if !isValidURL("http://" + fullURL) {
-			return renderError("invalid full URL format")
+	// With fuzzyPaths, a link may not fold to the same path as another one
+	claimed, err := claimPathFold(ctx, path, link)
+	if err != nil || !claimed {
+		// Roll back even if the request ran out of time
+		cleanupCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), storageTimeout)
+		defer cancel()
+		if _, deleteErr := linkStore.DeleteLink(cleanupCtx, path); deleteErr != nil {
+			log.Printf("Failed to remove URL mapping for %s: %v", path, deleteErr)
 		}
-		fullURL = "http://" + fullURL
 	}
-
-	// Store the URL mapping
-	err = challengeStore.StoreURL(path, fullURL)
 	if err != nil {
-		log.Printf("Failed to store URL mapping: %v", err)
-		return c.String(http.StatusInternalServerError, "error storing URL mapping")
+		log.Printf("Failed to claim folded path for %s: %v", path, err)
+		return err
 	}
+	if !claimed {
+		return &shortenError{Code: "path_taken", Message: "path is too similar to an existing short link"}
+	}
+	return nil
+}
 
-	// Generate a new challenge for the success page
-	challenge, err := generateNewChallenge()
-	if err != nil {
-		log.Printf("Failed to generate new challenge: %v", err)
-		return c.String(http.StatusInternalServerError, "error generating new challenge")
+// validateFullURL checks a destination URL, adding http:// when it has no scheme.
+// Returns a *shortenError if the URL is invalid or blocked.
+func validateFullURL(fullURL string) (string, error) {
+	if fullURL == "" {
+		return "", &shortenError{Code: "url_required", Message: "full URL is required"}
+	}
+	if len(fullURL) > 200 {
+		return "", &shortenError{Code: "url_too_long", Message: "full URL is too long, must be less than 200 characters"}
 	}
 
-	// Render success page with shortened URL
-	data := TemplateData{
-		PoWChallenge:   challenge,
-		FullURL:        "",
-		Path:           "",
-		ErrorMessage:   "",
-		SuccessMessage: "URL shortened successfully! Your short URL is: wap.fyi/" + path,
+	// Check if the full URL is valid, if http:// is not provided, add it
+	if !isValidURL(fullURL) {
+		if !isValidURL("http://" + fullURL) {
+			return "", &shortenError{Code: "invalid_url", Message: "invalid full URL format"}
+		}
+		fullURL = "http://" + fullURL
 	}
 
-	return renderIndexWithData(c, data)
+	if blocklist.Blocks(fullURL) {
+		return "", &shortenError{Code: "url_blocked", Message: "this URL is not allowed"}
+	}
+
+	return fullURL, nil
 }
 
 // handleRedirectOrStatic handles requests that could be shortened URLs or static files
@@ -322,20 +603,41 @@ func handleRedirectOrStatic(c echo.Context) error {
 		return serveHome(c)
 	}
 
-	// Check if path matches shortened URL pattern [a-zA-Z0-9_-]
-	regexpPath := regexp.MustCompile(`^[a-zA-Z0-9_-]+$`)
-	if regexpPath.MatchString(path) && len(path) >= 1 && len(path) <= 20 {
-		// Try to get the full URL from storage
-		fullURL, exists, err := challengeStore.GetURL(path)
-		if err != nil {
-			log.Printf("Failed to retrieve URL mapping for %s: %v", path, err)
+	// A "+" or ".preview" suffix asks where a short link goes instead of following it
+	linkPath, preview := cutPreviewSuffix(path)
+
+	// Check if path can be a short link, using the same policy as when links are created
+	if pathPolicy.Allows(linkPath) {
+		ctx, cancel := storageContext(c)
+		defer cancel()
+
+		// Try to get the full URL from storage, linkPath becomes the path the link is stored at
+		link, linkPath, exists, err := lookupLink(ctx, linkPath)
+		if err != nil && isStorageTimeout(err) {
+			log.Printf("Timed out retrieving URL mapping for %s: %v", linkPath, err)
+			return serveDegraded(c)
+		} else if err != nil {
+			log.Printf("Failed to retrieve URL mapping for %s: %v", linkPath, err)
 			// Fall through to static file serving
+		} else if exists && isDisabled(link) {
+			// The link was disabled or its destination blocked after it was created
+			return serveDisabled(c)
+		} else if exists && preview {
+			return servePreview(c, linkPath, link)
 		} else if exists {
-			// Redirect to the full URL
-			return c.Redirect(http.StatusMovedPermanently, fullURL)
+			// Count the click without waiting for storage and redirect to the full URL.
+			// Links can be edited, deleted or disabled, so the redirect must not be cached.
+			clickRecorder.Record(linkPath, Click{Class: classifyClient(c), At: time.Now(), ExpiresAt: link.ExpiresAt})
+			c.Response().Header().Set("Cache-Control", "no-store")
+			return c.Redirect(http.StatusFound, link.URL)
 		}
 	}
 
+	// Previews only exist for short links, never for static files
+	if preview {
+		return serve404(c)
+	}
+
 	// Security: Protect against path traversal attacks
 	// Clean the path and ensure it doesn't contain path traversal sequences
 	cleanPath := filepath.Clean(path)
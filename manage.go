@@ -0,0 +1,206 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"html/template"
+	"log"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// manageTokenLength is the length of the secret token that lets the creator of a link manage it
+const manageTokenLength = 16
+
+// ManageData holds data for rendering the manage templates
+type ManageData struct {
+	Path           string
+	ShortURL       string
+	URL            string
+	Expires        string
+	Token          string
+	Lifetimes      []LinkLifetime
+	Deleted        bool
+	ErrorMessage   string
+	SuccessMessage string
+}
+
+// hashManageToken hashes a management token for storage, so a leaked database can't be used to edit links
+func hashManageToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// ownsLink reports whether token is the management token of link
+func ownsLink(link Link, token string) bool {
+	if link.OwnerHash == "" || token == "" {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(hashManageToken(token)), []byte(link.OwnerHash)) == 1
+}
+
+// managePath returns the secret management page of the link at path
+func managePath(path, token string) string {
+	return "/manage/" + path + "?token=" + url.QueryEscape(token)
+}
+
+// keepTokenPrivate sets headers on pages that carry a token in their URL, so browsers don't send
+// the URL to the sites they link to in the Referer header or keep the page in shared caches
+func keepTokenPrivate(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		c.Response().Header().Set("Referrer-Policy", "no-referrer")
+		c.Response().Header().Set("Cache-Control", "no-store")
+		return next(c)
+	}
+}
+
+// extendExpiry returns the later of two expiry times, where zero means never
+func extendExpiry(current, extended time.Time) time.Time {
+	if current.IsZero() || (!extended.IsZero() && !extended.After(current)) {
+		return current
+	}
+	return extended
+}
+
+// loadOwnedLink looks up the link at path if token manages it.
+// Returns false when the link doesn't exist or the token is wrong, so the two can't be told apart.
+func loadOwnedLink(ctx context.Context, path, token string) (Link, bool, error) {
+	if !pathPolicy.Allows(path) {
+		return Link{}, false, nil
+	}
+
+	link, exists, err := linkStore.GetLink(ctx, path)
+	if err != nil || !exists || !ownsLink(link, token) {
+		return Link{}, false, err
+	}
+	return link, true, nil
+}
+
+// renderManage renders the manage page for the client's markup language
+func renderManage(c echo.Context, data ManageData) error {
+	data.ShortURL = shortURLPrefix + data.Path
+	data.Lifetimes = linkLifetimes
+
+	if acceptsWML(c) {
+		return renderWML(c, http.StatusOK, "manage.wml", data)
+	}
+
+	tmpl := template.Must(template.ParseFiles("./templates/manage.html"))
+	c.Response().Header().Set("Content-Type", "text/html")
+	c.Response().WriteHeader(http.StatusOK)
+	return tmpl.Execute(c.Response().Writer, data)
+}
+
+// handleManage shows the management page of a link to the holder of its token
+func handleManage(c echo.Context) error {
+	path := c.Param("path")
+	token := c.QueryParam("token")
+
+	ctx, cancel := storageContext(c)
+	defer cancel()
+
+	link, ok, err := loadOwnedLink(ctx, path, token)
+	if err != nil {
+		log.Printf("Failed to retrieve URL mapping for %s: %v", path, err)
+		return serveStorageError(c, err)
+	}
+	if !ok {
+		return serve404(c)
+	}
+
+	return renderManage(c, ManageData{
+		Path:    path,
+		URL:     link.URL,
+		Expires: formatLinkTime(link.ExpiresAt, "never"),
+		Token:   token,
+	})
+}
+
+// handleManagePost changes the destination or expiry of a link, or deletes it
+func handleManagePost(c echo.Context) error {
+	path := c.Param("path")
+	token := c.FormValue("token")
+
+	ctx, cancel := storageContext(c)
+	defer cancel()
+
+	link, ok, err := loadOwnedLink(ctx, path, token)
+	if err != nil {
+		log.Printf("Failed to retrieve URL mapping for %s: %v", path, err)
+		return serveStorageError(c, err)
+	}
+	if !ok {
+		return serve404(c)
+	}
+
+	data := ManageData{
+		Path:  path,
+		Token: token,
+	}
+
+	if c.FormValue("action") == "delete" {
+		if _, err := linkStore.DeleteLink(ctx, path); err != nil {
+			log.Printf("Failed to delete URL mapping for %s: %v", path, err)
+			return serveStorageError(c, err)
+		}
+		if err := releasePathFold(ctx, path); err != nil {
+			log.Printf("Failed to release folded path for %s: %v", path, err)
+		}
+		data.Deleted = true
+		data.SuccessMessage = "Link deleted, " + shortURLPrefix + path + " is free again"
+		return renderManage(c, data)
+	}
+
+	renderError := func(errorMsg string) error {
+		data.URL = link.URL
+		data.Expires = formatLinkTime(link.ExpiresAt, "never")
+		data.ErrorMessage = errorMsg
+		return renderManage(c, data)
+	}
+
+	fullURL, err := validateFullURL(c.FormValue("fullURL"))
+	if err != nil {
+		return renderError(err.Error())
+	}
+	var shortenErr *shortenError
+	if err := checkLinkChain(ctx, path, fullURL); errors.As(err, &shortenErr) {
+		return renderError(shortenErr.Message)
+	} else if err != nil {
+		return serveStorageError(c, err)
+	}
+	link.URL = fullURL
+
+	// A lifetime counts from now and only ever extends the current expiry, an empty one keeps it
+	if lifetimeName := c.FormValue("lifetime"); lifetimeName != "" {
+		lifetime, ok := findLinkLifetime(lifetimeName)
+		if !ok {
+			return renderError("invalid lifetime")
+		}
+		link.ExpiresAt = extendExpiry(link.ExpiresAt, lifetime.ExpiresAt(time.Now()))
+	}
+
+	updated, err := linkStore.UpdateLink(ctx, path, link)
+	if err != nil {
+		log.Printf("Failed to update URL mapping for %s: %v", path, err)
+		return serveStorageError(c, err)
+	}
+	if !updated {
+		// The link expired while its owner was editing it
+		return serve404(c)
+	}
+	// Keep the folded path for as long as the link lives
+	if _, err := claimPathFold(ctx, path, link); err != nil {
+		log.Printf("Failed to refresh folded path for %s: %v", path, err)
+	}
+
+	data.URL = link.URL
+	data.Expires = formatLinkTime(link.ExpiresAt, "never")
+	data.SuccessMessage = "Link updated"
+	return renderManage(c, data)
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// doForm posts form values to e and returns the recorded response
+func doForm(e http.Handler, target string, values url.Values) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, target, strings.NewReader(values.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestManageLink(t *testing.T) {
+	e := newTestServer(t)
+
+	challenge, solution := fetchSolvedChallenge(t, e)
+	body, _ := json.Marshal(map[string]interface{}{
+		"url":           "http://example.com",
+		"path":          "mine",
+		"pow_challenge": challenge,
+		"pow_solution":  solution,
+	})
+	var created apiLinkResponse
+	if code := doJSON(t, e, http.MethodPost, "/api/v1/links", string(body), &created); code != http.StatusCreated {
+		t.Fatalf("POST /api/v1/links = %d", code)
+	}
+	if created.ManageToken == "" {
+		t.Fatalf("no manage token returned")
+	}
+	link, _, _ := linkStore.GetLink(context.Background(), "mine")
+	if link.OwnerHash == "" || strings.Contains(link.OwnerHash, created.ManageToken) {
+		t.Errorf("stored owner hash %q must be a hash of the token", link.OwnerHash)
+	}
+
+	for _, tt := range []struct {
+		target string
+		status int
+	}{
+		{"/manage/mine", http.StatusNotFound},
+		{"/manage/mine?token=wrong", http.StatusNotFound},
+		{"/manage/mine?token=" + created.ManageToken, http.StatusOK},
+		{"/stats/mine?token=" + created.ManageToken, http.StatusOK},
+	} {
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, tt.target, nil))
+		if rec.Code != tt.status {
+			t.Errorf("GET %s = %d, expected %d", tt.target, rec.Code, tt.status)
+		}
+		if policy := rec.Header().Get("Referrer-Policy"); policy != "no-referrer" {
+			t.Errorf("GET %s has Referrer-Policy %q, expected no-referrer", tt.target, policy)
+		}
+	}
+
+	// A wrong token can't change anything
+	rec := doForm(e, "/manage/mine", url.Values{"token": {"wrong"}, "action": {"delete"}})
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("delete with a wrong token = %d, expected %d", rec.Code, http.StatusNotFound)
+	}
+
+	rec = doForm(e, "/manage/mine", url.Values{"token": {created.ManageToken}, "action": {"update"}, "fullURL": {"ftp://example.org"}})
+	if !strings.Contains(rec.Body.String(), "invalid full URL format") {
+		t.Errorf("invalid update was not rejected:\n%s", rec.Body.String())
+	}
+
+	rec = doForm(e, "/manage/mine", url.Values{"token": {created.ManageToken}, "action": {"update"}, "fullURL": {"example.org"}, "lifetime": {"1w"}})
+	if !strings.Contains(rec.Body.String(), "Link updated") {
+		t.Errorf("update failed:\n%s", rec.Body.String())
+	}
+	link, _, _ = linkStore.GetLink(context.Background(), "mine")
+	if link.URL != "http://example.org" || link.ExpiresAt.IsZero() {
+		t.Errorf("link after update = %+v", link)
+	}
+
+	// A shorter lifetime doesn't cut the link short
+	rec = doForm(e, "/manage/mine", url.Values{"token": {created.ManageToken}, "action": {"update"}, "fullURL": {"example.org"}, "lifetime": {"1h"}})
+	if !strings.Contains(rec.Body.String(), "Link updated") {
+		t.Errorf("update failed:\n%s", rec.Body.String())
+	}
+	if shortened, _, _ := linkStore.GetLink(context.Background(), "mine"); !shortened.ExpiresAt.Equal(link.ExpiresAt) {
+		t.Errorf("expiry changed from %v to %v, expected it to be kept", link.ExpiresAt, shortened.ExpiresAt)
+	}
+
+	rec = doForm(e, "/manage/mine", url.Values{"token": {created.ManageToken}, "action": {"delete"}})
+	if !strings.Contains(rec.Body.String(), "Link deleted") {
+		t.Errorf("delete failed:\n%s", rec.Body.String())
+	}
+	if _, exists, _ := linkStore.GetLink(context.Background(), "mine"); exists {
+		t.Errorf("link still exists after delete")
+	}
+}
+
+func TestExtendExpiry(t *testing.T) {
+	now := time.Date(2001, 9, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		current  time.Time
+		extended time.Time
+		expected time.Time
+	}{
+		{now, now.Add(time.Hour), now.Add(time.Hour)},
+		{now.Add(time.Hour), now, now.Add(time.Hour)},
+		{now, time.Time{}, time.Time{}},
+		{time.Time{}, now, time.Time{}},
+	}
+	for _, tt := range tests {
+		if got := extendExpiry(tt.current, tt.extended); !got.Equal(tt.expected) {
+			t.Errorf("extendExpiry(%v, %v) = %v, expected %v", tt.current, tt.extended, got, tt.expected)
+		}
+	}
+}
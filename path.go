@@ -0,0 +1,73 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+const (
+	// defaultPathMinLength and defaultPathMaxLength bound short paths when PATH_MIN_LENGTH and PATH_MAX_LENGTH are not set
+	defaultPathMinLength = 1
+	defaultPathMaxLength = 50
+)
+
+// pathPattern matches the characters allowed in short paths
+var pathPattern = regexp.MustCompile(`^[a-zA-Z0-9_-]+$`)
+
+// PathPolicy decides which paths can be short links. The same policy is used to create
+// links and to look them up, so every link that can be created also resolves.
+type PathPolicy struct {
+	MinLength int
+	MaxLength int
+}
+
+// pathPolicy is the policy of this server
+var pathPolicy = PathPolicy{MinLength: defaultPathMinLength, MaxLength: defaultPathMaxLength}
+
+// parsePathPolicy builds a policy from the PATH_MIN_LENGTH and PATH_MAX_LENGTH values, empty values keep the defaults.
+// The limits have to leave room for the random paths of every slug style.
+func parsePathPolicy(minLength string, maxLength string) (PathPolicy, error) {
+	policy := PathPolicy{MinLength: defaultPathMinLength, MaxLength: defaultPathMaxLength}
+
+	var err error
+	if strings.TrimSpace(minLength) != "" {
+		if policy.MinLength, err = strconv.Atoi(strings.TrimSpace(minLength)); err != nil {
+			return PathPolicy{}, fmt.Errorf("invalid minimum path length %q", minLength)
+		}
+	}
+	if strings.TrimSpace(maxLength) != "" {
+		if policy.MaxLength, err = strconv.Atoi(strings.TrimSpace(maxLength)); err != nil {
+			return PathPolicy{}, fmt.Errorf("invalid maximum path length %q", maxLength)
+		}
+	}
+
+	if policy.MinLength < 1 || policy.MaxLength < policy.MinLength {
+		return PathPolicy{}, fmt.Errorf("path lengths must satisfy 1 <= minimum <= maximum, got %d and %d", policy.MinLength, policy.MaxLength)
+	}
+	for _, style := range slugStyles {
+		if style.Length < policy.MinLength || style.Length > policy.MaxLength {
+			return PathPolicy{}, fmt.Errorf("%s random paths are %d characters, outside of %d to %d", style.Name, style.Length, policy.MinLength, policy.MaxLength)
+		}
+	}
+
+	return policy, nil
+}
+
+// Validate checks path against the policy.
+// Returns a *shortenError describing what is wrong, or nil if path is allowed.
+func (p PathPolicy) Validate(path string) error {
+	if len(path) < p.MinLength || len(path) > p.MaxLength {
+		return &shortenError{Code: "invalid_path_length", Message: fmt.Sprintf("invalid path length, must be between %d and %d characters", p.MinLength, p.MaxLength)}
+	}
+	if !pathPattern.MatchString(path) {
+		return &shortenError{Code: "invalid_path_format", Message: "invalid path format, must contain only [a-zA-Z0-9_-]"}
+	}
+	return nil
+}
+
+// Allows reports whether path can be a short link
+func (p PathPolicy) Allows(path string) bool {
+	return p.Validate(path) == nil
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestParsePathPolicy(t *testing.T) {
+	tests := []struct {
+		minLength string
+		maxLength string
+		want      PathPolicy
+		valid     bool
+	}{
+		{"", "", PathPolicy{MinLength: 1, MaxLength: 50}, true},
+		{"3", " 20 ", PathPolicy{MinLength: 3, MaxLength: 20}, true},
+		{"5", "7", PathPolicy{MinLength: 5, MaxLength: 7}, true},
+		{"0", "", PathPolicy{}, false},
+		{"10", "5", PathPolicy{}, false},
+		{"x", "", PathPolicy{}, false},
+		{"", "6", PathPolicy{}, false}, // too short for keypad and digits paths
+		{"6", "", PathPolicy{}, false}, // too long for random paths
+	}
+	for _, tt := range tests {
+		got, err := parsePathPolicy(tt.minLength, tt.maxLength)
+		if (err == nil) != tt.valid || got != tt.want {
+			t.Errorf("parsePathPolicy(%q, %q) = %+v, %v", tt.minLength, tt.maxLength, got, err)
+		}
+	}
+}
+
+func TestPathPolicyEndToEnd(t *testing.T) {
+	policies := []PathPolicy{
+		{MinLength: defaultPathMinLength, MaxLength: defaultPathMaxLength},
+		{MinLength: 3, MaxLength: 8},
+	}
+
+	for _, policy := range policies {
+		t.Run(strconv.Itoa(policy.MinLength)+"-"+strconv.Itoa(policy.MaxLength), func(t *testing.T) {
+			e := newTestServer(t)
+			oldPolicy := pathPolicy
+			pathPolicy = policy
+			t.Cleanup(func() { pathPolicy = oldPolicy })
+
+			tests := []struct {
+				length int
+				valid  bool
+			}{
+				{policy.MinLength - 1, false},
+				{policy.MinLength, true},
+				{policy.MaxLength, true},
+				{policy.MaxLength + 1, false},
+			}
+			for _, tt := range tests {
+				if tt.length < 1 {
+					continue // an empty path picks a random one
+				}
+				path := strings.Repeat("p", tt.length)
+				challenge, solution := fetchSolvedChallenge(t, e)
+				rec := doForm(e, "/shorten.html", url.Values{
+					"fullURL":       {"http://example.com/" + path},
+					"path":          {path},
+					"pow_challenge": {challenge},
+					"pow_solution":  {strconv.Itoa(solution)},
+				})
+				created := strings.Contains(rec.Body.String(), "URL shortened successfully")
+				if created != tt.valid {
+					t.Errorf("creating a %d character path: created = %t, expected %t", tt.length, created, tt.valid)
+				}
+
+				// Store invalid paths behind the policy's back, they still must not resolve
+				if !tt.valid {
+					storeTestLink(t, linkStore, path, Link{URL: "http://example.com/" + path})
+				}
+
+				req := httptest.NewRequest(http.MethodGet, "/"+path, nil)
+				rec = httptest.NewRecorder()
+				e.ServeHTTP(rec, req)
+				redirected := rec.Code == http.StatusFound && rec.Header().Get("Location") == "http://example.com/"+path
+				if redirected != tt.valid {
+					t.Errorf("GET /%s = %d, redirected = %t, expected %t", path, rec.Code, redirected, tt.valid)
+				}
+			}
+		})
+	}
+}
@@ -5,6 +5,36 @@ import (
 	"strconv"
 )
 
+// PoWProfile describes the proof of work parameters handed out to a class of clients.
+// Difficulty is used when the server is quiet, under load it rises up to MaxDifficulty.
+type PoWProfile struct {
+	Name          string
+	Difficulty    int
+	MaxDifficulty int
+}
+
+var (
+	// HTMLProfile is used for desktop browsers solving with captcha.js
+	HTMLProfile = PoWProfile{Name: "html", Difficulty: 4, MaxDifficulty: 6}
+	// WMLProfile is used for WAP handsets solving with captcha.wmls.
+	// Two trailing zeros take a few hundred hashes, which a Nokia 7110 finishes in seconds.
+	// Three take thousands, four would take tens of thousands and never finish on a handset.
+	WMLProfile = PoWProfile{Name: "wml", Difficulty: 2, MaxDifficulty: 3}
+	// APIProfile is used for scripts creating links through the JSON API
+	APIProfile = PoWProfile{Name: "api", Difficulty: 4, MaxDifficulty: 6}
+)
+
+// powMaxIterations is how many solutions a client tries before giving up on a challenge.
+// Each try ends in difficulty hex zeros with a chance of 1 in 16^difficulty, so eight
+// times that many tries fail less than once in a thousand challenges.
+func powMaxIterations(difficulty int) int {
+	iterations := 8
+	for i := 0; i < difficulty; i++ {
+		iterations *= 16
+	}
+	return iterations
+}
+
 // simpleHash implements the same hash function as the JavaScript version
 // This is compatible with Netscape 4+ browsers
 func simpleHash(str string) uint32 {
@@ -1,6 +1,8 @@
 package main
 
 import (
+	"strconv"
+	"strings"
 	"testing"
 )
 
@@ -83,3 +85,103 @@ func TestVerifyProofOfWork(t *testing.T) {
 	}
 
 }
+
+func TestPoWMaxIterations(t *testing.T) {
+	if got := powMaxIterations(2); got != 8*16*16 {
+		t.Errorf("powMaxIterations(2) = %d, expected %d", got, 8*16*16)
+	}
+	// Handsets must be able to finish the hardest challenge they are given
+	if got := powMaxIterations(WMLProfile.MaxDifficulty); got > 40000 {
+		t.Errorf("WML challenges may take %d tries, too many for a handset", got)
+	}
+}
+
+// captchaVectors are solved challenges checked against both simpleHash and the WMLScript
+// solver in templates/captcha.wmls. Keep them in sync when either hash changes.
+var captchaVectors = []struct {
+	challenge string
+	solution  int
+	hash      uint32
+	zeros     int
+}{
+	{"a", 0, 0x00000bef, 0},
+	{"a", 76, 0x00017300, 2},
+	{"hello", 112, 0x2f3fe400, 2}, // the 32 bit hash is negative
+	{"hello", 233, 0x2f3fe000, 3},
+	{"1.AbC-_x~", 47, 0x06f55e00, 2},
+	{"eZwqr4RTaVbQDkrm9R3wAL3PbTZN41Zpe7NfWrig7m1YyCpcWYnVwn0fcihRfTp7", 122, 0x59a84600, 2},
+}
+
+// The wmls functions port the arithmetic of templates/captcha.wmls line by line,
+// using int32 for the 32 bit integers of WMLScript
+
+func wmlsAdd(a, b int32) int32 {
+	var carry int32
+	for b != 0 {
+		carry = a & b
+		a = a ^ b
+		b = carry << 1
+	}
+	return a
+}
+
+func wmlsHashFrom(hash int32, str string) int32 {
+	table := " !\"#$%&'()*+,-./0123456789:;<=>?@ABCDEFGHIJKLMNOPQRSTUVWXYZ[\\]^_`abcdefghijklmnopqrstuvwxyz{|}~"
+	for i := 0; i < len(str); i++ {
+		code := int32(strings.IndexByte(table, str[i])) + 32
+		hash = wmlsAdd(wmlsAdd(hash<<5, wmlsAdd(^hash, 1)), code)
+	}
+	return hash
+}
+
+func wmlsHasTrailingZeros(hash int32, zeros int) bool {
+	var mask int32
+	if hash == 0 {
+		return false
+	}
+	for i := 0; i < zeros; i++ {
+		mask = (mask << 4) | 15
+	}
+	return hash&mask == 0
+}
+
+// wmlsSolves reports whether the WMLScript solver accepts solution for challenge
+func wmlsSolves(challenge string, solution, difficulty int) (int32, bool) {
+	hash := wmlsHashFrom(wmlsHashFrom(0, challenge), strconv.Itoa(solution))
+	return hash, wmlsHasTrailingZeros(hash, difficulty)
+}
+
+func TestCaptchaVectors(t *testing.T) {
+	for _, tc := range captchaVectors {
+		input := tc.challenge + strconv.Itoa(tc.solution)
+		if hash := simpleHash(input); hash != tc.hash {
+			t.Errorf("simpleHash(%q) = 0x%08x, expected 0x%08x", input, hash, tc.hash)
+		}
+		hash, solved := wmlsSolves(tc.challenge, tc.solution, tc.zeros)
+		if hash < 0 {
+			hash = -hash
+		}
+		if uint32(hash) != tc.hash || !solved {
+			t.Errorf("captcha.wmls hashes %q to 0x%08x with %d zeros %t, expected 0x%08x", input, uint32(hash), tc.zeros, solved, tc.hash)
+		}
+		if _, solved := wmlsSolves(tc.challenge, tc.solution, tc.zeros+1); solved {
+			t.Errorf("captcha.wmls finds more than %d zeros in the hash of %q", tc.zeros, input)
+		}
+	}
+}
+
+func TestCaptchaSolverMatchesVerifier(t *testing.T) {
+	// Real challenges use the URL safe base64 alphabet and dots
+	challenges := []string{"", "a", "hello", "1.AbC-_x~", "eZwqr4RTaVbQDkrm9R3wAL3PbTZN41Zpe7NfWrig7m1YyCpcWYnVwn0fcihRfTp7"}
+	for _, challenge := range challenges {
+		for solution := 0; solution < 5000; solution++ {
+			for difficulty := 1; difficulty <= WMLProfile.MaxDifficulty; difficulty++ {
+				_, solved := wmlsSolves(challenge, solution, difficulty)
+				if verified := VerifyProofOfWork(challenge, solution, difficulty); solved != verified {
+					t.Fatalf("solution %d of %q at difficulty %d: captcha.wmls says %t, VerifyProofOfWork says %t",
+						solution, challenge, difficulty, solved, verified)
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"html/template"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// previewSuffixes turn a short link into a preview of its destination, e.g. /abc+ or /abc.preview
+var previewSuffixes = []string{"+", ".preview"}
+
+// PreviewData holds data for rendering the preview templates
+type PreviewData struct {
+	ShortURL string
+	URL      string
+	Created  string
+	Expires  string
+}
+
+// cutPreviewSuffix strips a preview suffix from path, reporting whether it had one
+func cutPreviewSuffix(path string) (string, bool) {
+	for _, suffix := range previewSuffixes {
+		if trimmed, ok := strings.CutSuffix(path, suffix); ok {
+			return trimmed, true
+		}
+	}
+	return path, false
+}
+
+// formatLinkTime formats a link timestamp for the preview page, or returns fallback for the zero time
+func formatLinkTime(t time.Time, fallback string) string {
+	if t.IsZero() {
+		return fallback
+	}
+	return t.UTC().Format("2006-01-02 15:04 MST")
+}
+
+// servePreview shows where the link at path goes instead of redirecting to it
+func servePreview(c echo.Context, path string, link Link) error {
+	data := PreviewData{
+		ShortURL: shortURLPrefix + path,
+		URL:      link.URL,
+		Created:  formatLinkTime(link.CreatedAt, "unknown"),
+		Expires:  formatLinkTime(link.ExpiresAt, "never"),
+	}
+
+	if acceptsWML(c) {
+		return renderWML(c, http.StatusOK, "preview.wml", data)
+	}
+
+	tmpl := template.Must(template.ParseFiles("./templates/preview.html"))
+	c.Response().Header().Set("Content-Type", "text/html")
+	c.Response().WriteHeader(http.StatusOK)
+	return tmpl.Execute(c.Response().Writer, data)
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPreview(t *testing.T) {
+	e := newTestServer(t)
+
+	created := time.Date(2001, 9, 1, 12, 0, 0, 0, time.UTC)
+	link := Link{URL: "http://example.com/?a=1&b=2", CreatedAt: created}
+	storeTestLink(t, linkStore, "peek", link)
+
+	tests := []struct {
+		target      string
+		accept      string
+		status      int
+		contentType string
+		contains    []string
+	}{
+		{"/peek+", "text/html", http.StatusOK, "text/html", []string{"http://example.com/?a=1&amp;b=2", "2001-09-01 12:00 UTC", "never"}},
+		{"/peek.preview", "text/html", http.StatusOK, "text/html", []string{"wap.fyi/peek"}},
+		{"/peek+", "text/vnd.wap.wml", http.StatusOK, wmlContentType, []string{`<go href="http://example.com/?a=1&amp;b=2"/>`, "Created: 2001-09-01 12:00 UTC"}},
+		{"/missing+", "text/html", http.StatusNotFound, "", nil},
+		{"/index.html+", "text/html", http.StatusNotFound, "", nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.target+" "+tt.accept, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, tt.target, nil)
+			req.Header.Set("Accept", tt.accept)
+			rec := httptest.NewRecorder()
+			e.ServeHTTP(rec, req)
+
+			if rec.Code != tt.status {
+				t.Fatalf("status = %d, expected %d", rec.Code, tt.status)
+			}
+			if tt.contentType != "" && !strings.HasPrefix(rec.Header().Get("Content-Type"), tt.contentType) {
+				t.Errorf("Content-Type = %s, expected %s", rec.Header().Get("Content-Type"), tt.contentType)
+			}
+			for _, want := range tt.contains {
+				if !strings.Contains(rec.Body.String(), want) {
+					t.Errorf("body does not contain %q:\n%s", want, rec.Body.String())
+				}
+			}
+		})
+	}
+
+	// Without a suffix the link still redirects
+	req := httptest.NewRequest(http.MethodGet, "/peek", nil)
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	if rec.Code != http.StatusFound || rec.Header().Get("Location") != link.URL {
+		t.Errorf("GET /peek = %d to %s, expected a redirect to %s", rec.Code, rec.Header().Get("Location"), link.URL)
+	}
+	if cache := rec.Header().Get("Cache-Control"); cache != "no-store" {
+		t.Errorf("GET /peek has Cache-Control %q, expected no-store", cache)
+	}
+}
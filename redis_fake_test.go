@@ -0,0 +1,505 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeRedis is an in-process stand-in for Redis that speaks enough RESP2 for RedisStorage:
+// strings and hashes with expiry, MULTI/EXEC and the Lua scripts of RedisStorage, which it
+// runs as Go functions. Keys expire by its own clock, so tests can move time along. Like a
+// cluster node it refuses transactions and scripts whose keys are in different slots.
+type fakeRedis struct {
+	listener net.Listener
+	now      func() time.Time
+	scripts  map[string]fakeRedisScript // by SHA1 of the Lua source
+
+	mu   sync.Mutex
+	keys map[string]*fakeRedisValue
+}
+
+// fakeRedisValue is a string or a hash, with its expiry time or zero to keep it forever
+type fakeRedisValue struct {
+	str       string
+	hash      map[string]string
+	expiresAt time.Time
+}
+
+// fakeRedisScript runs a Lua script of RedisStorage, with the lock held
+type fakeRedisScript func(f *fakeRedis, keys, args []string) interface{}
+
+// respStatus and respError are the simple string and error replies, a string is a bulk reply
+type (
+	respStatus string
+	respError  string
+)
+
+// newFakeRedis starts a fake Redis on a loopback port, stopped when the test ends
+func newFakeRedis(t *testing.T, now func() time.Time) *fakeRedis {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Skipf("can't listen for the fake Redis: %v", err)
+	}
+
+	f := &fakeRedis{
+		listener: listener,
+		now:      now,
+		keys:     make(map[string]*fakeRedisValue),
+		scripts: map[string]fakeRedisScript{
+			claimPathFoldScript.Hash():   fakeClaimPathFold,
+			releasePathFoldScript.Hash(): fakeReleasePathFold,
+			recordClickScript.Hash():     fakeRecordClick,
+		},
+	}
+	go f.serve()
+	t.Cleanup(func() { listener.Close() })
+
+	return f
+}
+
+// Addr returns the address clients connect to
+func (f *fakeRedis) Addr() string {
+	return f.listener.Addr().String()
+}
+
+// newFakeRedisStorage connects a RedisStorage to a new fake Redis, closed when the test ends
+func newFakeRedisStorage(t *testing.T, now func() time.Time) *RedisStorage {
+	fake := newFakeRedis(t, now)
+	config, err := parseRedisURL("redis://" + fake.Addr())
+	if err != nil {
+		t.Fatalf("failed to parse the fake Redis URL: %v", err)
+	}
+	storage, err := NewRedisStorage(config)
+	if err != nil {
+		t.Fatalf("failed to connect to the fake Redis: %v", err)
+	}
+	t.Cleanup(func() { storage.Close() })
+	return storage
+}
+
+// serve accepts connections until the listener is closed
+func (f *fakeRedis) serve() {
+	for {
+		conn, err := f.listener.Accept()
+		if err != nil {
+			return
+		}
+		go f.handle(conn)
+	}
+}
+
+// handle answers the commands of one connection, queueing them between MULTI and EXEC
+func (f *fakeRedis) handle(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	w := bufio.NewWriter(conn)
+
+	var queued [][]string
+	inMulti := false
+	for {
+		args, err := readRESPCommand(r)
+		if err != nil {
+			return
+		}
+
+		var reply interface{}
+		switch name := strings.ToUpper(args[0]); {
+		case name == "MULTI":
+			inMulti, queued = true, nil
+			reply = respStatus("OK")
+		case name == "EXEC" && inMulti:
+			var keys []string
+			for _, cmd := range queued {
+				keys = append(keys, fakeRedisKeys(cmd)...)
+			}
+			if !sameSlot(keys) {
+				reply = respError("CROSSSLOT Keys in request don't hash to the same slot")
+			} else {
+				f.mu.Lock()
+				replies := make([]interface{}, len(queued))
+				for i, cmd := range queued {
+					replies[i] = f.exec(cmd)
+				}
+				f.mu.Unlock()
+				reply = replies
+			}
+			inMulti, queued = false, nil
+		case name == "DISCARD" && inMulti:
+			inMulti, queued = false, nil
+			reply = respStatus("OK")
+		case inMulti:
+			queued = append(queued, args)
+			reply = respStatus("QUEUED")
+		default:
+			f.mu.Lock()
+			reply = f.exec(args)
+			f.mu.Unlock()
+		}
+
+		writeRESPReply(w, reply)
+		if r.Buffered() == 0 {
+			if err := w.Flush(); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// lookup returns the value of key, removing it if it has expired
+func (f *fakeRedis) lookup(key string) *fakeRedisValue {
+	value, exists := f.keys[key]
+	if !exists {
+		return nil
+	}
+	if !value.expiresAt.IsZero() && !f.now().Before(value.expiresAt) {
+		delete(f.keys, key)
+		return nil
+	}
+	return value
+}
+
+// exec runs a single command with the lock held and returns its reply
+func (f *fakeRedis) exec(args []string) interface{} {
+	name := strings.ToUpper(args[0])
+	args = args[1:]
+
+	switch name {
+	case "HELLO":
+		// Like Redis before 6, which makes go-redis stay on RESP2
+		return respError("ERR unknown command 'HELLO'")
+	case "PING":
+		return respStatus("PONG")
+	case "CLIENT", "SELECT":
+		return respStatus("OK")
+	case "GET":
+		value := f.lookup(args[0])
+		if value == nil {
+			return nil
+		}
+		if value.hash != nil {
+			return respError("WRONGTYPE Operation against a key holding the wrong kind of value")
+		}
+		return value.str
+	case "SET":
+		return f.set(args)
+	case "SETNX":
+		if f.lookup(args[0]) != nil {
+			return int64(0)
+		}
+		f.keys[args[0]] = &fakeRedisValue{str: args[1]}
+		return int64(1)
+	case "DEL", "EXISTS":
+		var count int64
+		for _, key := range args {
+			if f.lookup(key) != nil {
+				count++
+				if name == "DEL" {
+					delete(f.keys, key)
+				}
+			}
+		}
+		return count
+	case "PERSIST":
+		value := f.lookup(args[0])
+		if value == nil || value.expiresAt.IsZero() {
+			return int64(0)
+		}
+		value.expiresAt = time.Time{}
+		return int64(1)
+	case "PTTL":
+		value := f.lookup(args[0])
+		if value == nil {
+			return int64(-2)
+		}
+		if value.expiresAt.IsZero() {
+			return int64(-1)
+		}
+		return value.expiresAt.Sub(f.now()).Milliseconds()
+	case "EXPIREAT", "PEXPIREAT":
+		n, err := strconv.ParseInt(args[1], 10, 64)
+		if err != nil {
+			return respError("ERR value is not an integer or out of range")
+		}
+		value := f.lookup(args[0])
+		if value == nil {
+			return int64(0)
+		}
+		if name == "EXPIREAT" {
+			value.expiresAt = time.Unix(n, 0)
+		} else {
+			value.expiresAt = time.UnixMilli(n)
+		}
+		return int64(1)
+	case "HINCRBY":
+		n, err := strconv.ParseInt(args[2], 10, 64)
+		if err != nil {
+			return respError("ERR value is not an integer or out of range")
+		}
+		return f.hincrBy(args[0], args[1], n)
+	case "HGETALL":
+		var fields []interface{}
+		if value := f.lookup(args[0]); value != nil {
+			for field, count := range value.hash {
+				fields = append(fields, field, count)
+			}
+		}
+		return fields
+	case "EVALSHA", "EVAL":
+		sha := args[0]
+		if name == "EVAL" {
+			sum := sha1.Sum([]byte(args[0]))
+			sha = hex.EncodeToString(sum[:])
+		}
+		script, known := f.scripts[sha]
+		if !known {
+			return respError("NOSCRIPT No matching script")
+		}
+		numKeys, err := strconv.Atoi(args[1])
+		if err != nil || numKeys > len(args)-2 {
+			return respError("ERR invalid number of keys")
+		}
+		if !sameSlot(args[2 : 2+numKeys]) {
+			return respError("CROSSSLOT Keys in request don't hash to the same slot")
+		}
+		return script(f, args[2:2+numKeys], args[2+numKeys:])
+	default:
+		return respError(fmt.Sprintf("ERR unknown command '%s'", name))
+	}
+}
+
+// set runs SET with the EX, PX, EXAT, PXAT, KEEPTTL, NX and XX options
+func (f *fakeRedis) set(args []string) interface{} {
+	key, str := args[0], args[1]
+	var expiresAt time.Time
+	var nx, xx, keepTTL bool
+
+	for i := 2; i < len(args); i++ {
+		option := strings.ToUpper(args[i])
+		switch option {
+		case "NX":
+			nx = true
+		case "XX":
+			xx = true
+		case "KEEPTTL":
+			keepTTL = true
+		case "EX", "PX", "EXAT", "PXAT":
+			if i+1 >= len(args) {
+				return respError("ERR syntax error")
+			}
+			i++
+			n, err := strconv.ParseInt(args[i], 10, 64)
+			if err != nil {
+				return respError("ERR value is not an integer or out of range")
+			}
+			switch option {
+			case "EX":
+				expiresAt = f.now().Add(time.Duration(n) * time.Second)
+			case "PX":
+				expiresAt = f.now().Add(time.Duration(n) * time.Millisecond)
+			case "EXAT":
+				expiresAt = time.Unix(n, 0)
+			case "PXAT":
+				expiresAt = time.UnixMilli(n)
+			}
+		default:
+			return respError("ERR syntax error")
+		}
+	}
+
+	existing := f.lookup(key)
+	if (nx && existing != nil) || (xx && existing == nil) {
+		return nil
+	}
+	if keepTTL && existing != nil {
+		expiresAt = existing.expiresAt
+	}
+	f.keys[key] = &fakeRedisValue{str: str, expiresAt: expiresAt}
+	return respStatus("OK")
+}
+
+// hincrBy adds n to a hash field and returns the new count
+func (f *fakeRedis) hincrBy(key, field string, n int64) interface{} {
+	value := f.lookup(key)
+	if value == nil {
+		value = &fakeRedisValue{hash: make(map[string]string)}
+		f.keys[key] = value
+	}
+	if value.hash == nil {
+		return respError("WRONGTYPE Operation against a key holding the wrong kind of value")
+	}
+	count, _ := strconv.ParseInt(value.hash[field], 10, 64)
+	count += n
+	value.hash[field] = strconv.FormatInt(count, 10)
+	return count
+}
+
+// fakeClaimPathFold runs claimPathFoldScript
+func fakeClaimPathFold(f *fakeRedis, keys, args []string) interface{} {
+	if owner := f.lookup(keys[0]); owner != nil && owner.str != args[0] {
+		return owner.str
+	}
+	f.set(append([]string{keys[0], args[0]}, pxatOption(args[1])...))
+	return args[0]
+}
+
+// fakeReleasePathFold runs releasePathFoldScript
+func fakeReleasePathFold(f *fakeRedis, keys, args []string) interface{} {
+	if owner := f.lookup(keys[0]); owner != nil && owner.str == args[0] {
+		delete(f.keys, keys[0])
+		return int64(1)
+	}
+	return int64(0)
+}
+
+// fakeRecordClick runs recordClickScript
+func fakeRecordClick(f *fakeRedis, keys, args []string) interface{} {
+	if f.lookup(keys[0]) == nil {
+		return int64(0)
+	}
+	for _, field := range []string{"total", args[0], args[1]} {
+		f.hincrBy(keys[1], field, 1)
+	}
+	if args[2] != "0" {
+		f.exec([]string{"PEXPIREAT", keys[1], args[2]})
+	}
+	return int64(1)
+}
+
+// fakeRedisKeys returns the keys a command touches
+func fakeRedisKeys(args []string) []string {
+	switch strings.ToUpper(args[0]) {
+	case "PING", "HELLO", "CLIENT", "SELECT":
+		return nil
+	case "DEL", "EXISTS":
+		return args[1:]
+	case "EVALSHA", "EVAL":
+		numKeys, err := strconv.Atoi(args[2])
+		if err != nil || numKeys > len(args)-3 {
+			return nil
+		}
+		return args[3 : 3+numKeys]
+	default:
+		if len(args) < 2 {
+			return nil
+		}
+		return args[1:2]
+	}
+}
+
+// sameSlot tells whether keys all hash to the same cluster slot
+func sameSlot(keys []string) bool {
+	for _, key := range keys {
+		if keySlot(key) != keySlot(keys[0]) {
+			return false
+		}
+	}
+	return true
+}
+
+// keySlot returns the cluster slot of a key: the CRC16 of its hash tag, the part
+// between the first { and the next }, if that isn't empty, or else of the whole key
+func keySlot(key string) uint16 {
+	if start := strings.IndexByte(key, '{'); start >= 0 {
+		if end := strings.IndexByte(key[start+1:], '}'); end > 0 {
+			key = key[start+1 : start+1+end]
+		}
+	}
+
+	var crc uint16
+	for i := 0; i < len(key); i++ {
+		crc ^= uint16(key[i]) << 8
+		for bit := 0; bit < 8; bit++ {
+			if crc&0x8000 != 0 {
+				crc = crc<<1 ^ 0x1021
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+	return crc % 16384
+}
+
+// pxatOption returns the SET options for an expiry in unix milliseconds, none for "0"
+func pxatOption(millis string) []string {
+	if millis == "0" {
+		return nil
+	}
+	return []string{"PXAT", millis}
+}
+
+// readRESPCommand reads a command sent as an array of bulk strings
+func readRESPCommand(r *bufio.Reader) ([]string, error) {
+	line, err := readRESPLine(r)
+	if err != nil {
+		return nil, err
+	}
+	if !strings.HasPrefix(line, "*") {
+		return nil, fmt.Errorf("expected an array, got %q", line)
+	}
+	n, err := strconv.Atoi(line[1:])
+	if err != nil || n < 1 {
+		return nil, fmt.Errorf("invalid array length %q", line)
+	}
+
+	args := make([]string, n)
+	for i := range args {
+		line, err := readRESPLine(r)
+		if err != nil {
+			return nil, err
+		}
+		if !strings.HasPrefix(line, "$") {
+			return nil, fmt.Errorf("expected a bulk string, got %q", line)
+		}
+		size, err := strconv.Atoi(line[1:])
+		if err != nil || size < 0 {
+			return nil, fmt.Errorf("invalid bulk string length %q", line)
+		}
+		buf := make([]byte, size+2)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return nil, err
+		}
+		args[i] = string(buf[:size])
+	}
+	return args, nil
+}
+
+// readRESPLine reads a line without its CRLF
+func readRESPLine(r *bufio.Reader) (string, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSuffix(line, "\r\n"), nil
+}
+
+// writeRESPReply writes a reply in RESP2
+func writeRESPReply(w *bufio.Writer, reply interface{}) {
+	switch v := reply.(type) {
+	case nil:
+		w.WriteString("$-1\r\n")
+	case respStatus:
+		fmt.Fprintf(w, "+%s\r\n", v)
+	case respError:
+		fmt.Fprintf(w, "-%s\r\n", v)
+	case int64:
+		fmt.Fprintf(w, ":%d\r\n", v)
+	case string:
+		fmt.Fprintf(w, "$%d\r\n%s\r\n", len(v), v)
+	case []interface{}:
+		fmt.Fprintf(w, "*%d\r\n", len(v))
+		for _, item := range v {
+			writeRESPReply(w, item)
+		}
+	default:
+		panic(fmt.Sprintf("fake Redis can't reply with %T", reply))
+	}
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Redis deployments that can be picked with the scheme of REDIS_URL
+const (
+	redisSingle   = "single"
+	redisSentinel = "sentinel"
+	redisCluster  = "cluster"
+)
+
+// RedisConfig describes how to reach Redis or Valkey
+type RedisConfig struct {
+	Mode    string // redisSingle, redisSentinel or redisCluster
+	Options redis.UniversalOptions
+}
+
+// parseRedisURL parses a Redis URL in one of these forms:
+//
+//	redis://[user:password@]host[:port][/db]
+//	redis+sentinel://[user:password@]host[:port],host[:port]...[/db]?master=name[&sentinel_username=...][&sentinel_password=...]
+//	redis+cluster://[user:password@]host[:port],host[:port]...
+//
+// With rediss instead of redis the connections use TLS. The user and password log in
+// to Redis, as an ACL user if the user is set. Sentinels are listed instead of the
+// master, and any cluster node can be listed as a seed.
+func parseRedisURL(rawURL string) (RedisConfig, error) {
+	// net/url only takes a single host, so the list is parsed here
+	rawURL, hosts := cutRedisHosts(rawURL)
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return RedisConfig{}, fmt.Errorf("invalid Redis URL: %w", err)
+	}
+
+	config := RedisConfig{Mode: redisSingle}
+	scheme, mode, found := strings.Cut(u.Scheme, "+")
+	if found {
+		config.Mode = mode
+	}
+
+	switch scheme {
+	case "redis":
+	case "rediss":
+		config.Options.TLSConfig = &tls.Config{MinVersion: tls.VersionTLS12}
+	default:
+		return RedisConfig{}, fmt.Errorf("unsupported Redis URL scheme %q", u.Scheme)
+	}
+
+	defaultPort := "6379"
+	switch config.Mode {
+	case redisSingle, redisCluster:
+	case redisSentinel:
+		defaultPort = "26379"
+	default:
+		return RedisConfig{}, fmt.Errorf("unsupported Redis URL scheme %q, expected redis, redis+sentinel or redis+cluster", u.Scheme)
+	}
+
+	if hosts == "" {
+		return RedisConfig{}, fmt.Errorf("Redis URL has no host")
+	}
+	for _, host := range strings.Split(hosts, ",") {
+		if host == "" {
+			return RedisConfig{}, fmt.Errorf("Redis URL has an empty host")
+		}
+		if _, _, err := net.SplitHostPort(host); err != nil {
+			host = net.JoinHostPort(strings.Trim(host, "[]"), defaultPort)
+		}
+		config.Options.Addrs = append(config.Options.Addrs, host)
+	}
+	if config.Mode == redisSingle && len(config.Options.Addrs) > 1 {
+		return RedisConfig{}, fmt.Errorf("Redis URL lists more than one host, use redis+sentinel or redis+cluster")
+	}
+
+	config.Options.Username = u.User.Username()
+	config.Options.Password, _ = u.User.Password()
+
+	if db := strings.TrimPrefix(u.Path, "/"); db != "" {
+		config.Options.DB, err = strconv.Atoi(db)
+		if err != nil || config.Options.DB < 0 {
+			return RedisConfig{}, fmt.Errorf("invalid Redis database %q", db)
+		}
+	}
+	if config.Mode == redisCluster && config.Options.DB != 0 {
+		return RedisConfig{}, fmt.Errorf("Redis Cluster only has database 0")
+	}
+
+	query := u.Query()
+	config.Options.MasterName = query.Get("master")
+	config.Options.SentinelUsername = query.Get("sentinel_username")
+	config.Options.SentinelPassword = query.Get("sentinel_password")
+	if (config.Mode == redisSentinel) != (config.Options.MasterName != "") {
+		return RedisConfig{}, fmt.Errorf("the master name is required with redis+sentinel and only allowed there")
+	}
+
+	// A cluster with a single seed is still a cluster
+	config.Options.IsClusterMode = config.Mode == redisCluster
+
+	return config, nil
+}
+
+// cutRedisHosts takes the hosts out of a URL, leaving a placeholder host behind
+func cutRedisHosts(rawURL string) (string, string) {
+	scheme, rest, found := strings.Cut(rawURL, "://")
+	if !found {
+		return rawURL, ""
+	}
+	end := strings.IndexAny(rest, "/?#")
+	if end < 0 {
+		end = len(rest)
+	}
+	authority, rest := rest[:end], rest[end:]
+
+	userinfo := ""
+	if at := strings.LastIndexByte(authority, '@'); at >= 0 {
+		userinfo, authority = authority[:at+1], authority[at+1:]
+	}
+	return scheme + "://" + userinfo + "redis" + rest, authority
+}
+
+// redisConfigFromEnv reads REDIS_URL, or the single node in REDIS_ADDR and REDIS_PASSWORD if it is not set
+func redisConfigFromEnv() (RedisConfig, error) {
+	if rawURL := os.Getenv("REDIS_URL"); rawURL != "" {
+		config, err := parseRedisURL(rawURL)
+		if err != nil {
+			return RedisConfig{}, fmt.Errorf("invalid REDIS_URL: %w", err)
+		}
+		return config, nil
+	}
+
+	addr := os.Getenv("REDIS_ADDR")
+	if addr == "" {
+		addr = "localhost:6379"
+	}
+	return RedisConfig{
+		Mode: redisSingle,
+		Options: redis.UniversalOptions{
+			Addrs:    []string{addr},
+			Password: os.Getenv("REDIS_PASSWORD"),
+		},
+	}, nil
+}
+
+// String describes the deployment for logs, leaving out credentials
+func (c RedisConfig) String() string {
+	description := c.Mode + " " + strings.Join(c.Options.Addrs, ",")
+	if c.Options.MasterName != "" {
+		description += " master " + c.Options.MasterName
+	}
+	if c.Options.DB != 0 {
+		description += " db " + strconv.Itoa(c.Options.DB)
+	}
+	if c.Options.TLSConfig != nil {
+		description += " with TLS"
+	}
+	return description
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"os"
+	"strings"
+)
+
+// defaultReservedPaths can never be used as short paths, on top of the files in the templates directory
+// and the names in RESERVED_PATHS. They cover our routes and names we may want for pages later.
+const defaultReservedPaths = "admin,api,manage,stats,shorten,wap,static,help,about,login,logout,robots,favicon"
+
+// reservedPaths holds the reserved names in lower case
+var reservedPaths = make(map[string]bool)
+
+// loadReservedPaths builds the reserved names from the files in dir, with and without their
+// extension, the default list and the comma separated extra names
+func loadReservedPaths(dir string, extra string) (map[string]bool, error) {
+	reserved := make(map[string]bool)
+	add := func(name string) {
+		if name = strings.TrimSpace(name); name != "" {
+			reserved[strings.ToLower(name)] = true
+		}
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, entry := range entries {
+		add(entry.Name())
+		if base, _, found := strings.Cut(entry.Name(), "."); found {
+			add(base)
+		}
+	}
+
+	for _, name := range strings.Split(defaultReservedPaths+","+extra, ",") {
+		add(name)
+	}
+
+	return reserved, nil
+}
+
+// isReservedPath reports whether path collides with a reserved name, ignoring case and with
+// fuzzyPaths also the characters that fold together
+func isReservedPath(path string) bool {
+	if reservedPaths[strings.ToLower(path)] {
+		return true
+	}
+	if fuzzyPaths {
+		folded := foldPath(path)
+		for name := range reservedPaths {
+			if foldPath(name) == folded {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+)
+
+func TestLoadReservedPaths(t *testing.T) {
+	reserved, err := loadReservedPaths("templates", "Secret, other")
+	if err != nil {
+		t.Fatalf("loadReservedPaths failed: %v", err)
+	}
+	for _, name := range []string{"captcha", "captcha.js", "index", "index.wml", "404", "admin", "api", "manage", "wap", "secret", "other"} {
+		if !reserved[name] {
+			t.Errorf("%s is not reserved", name)
+		}
+	}
+	if reserved["hello"] || reserved[""] {
+		t.Errorf("unexpected reserved names in %v", reserved)
+	}
+
+	if _, err := loadReservedPaths("missing", ""); err == nil {
+		t.Errorf("expected an error for a missing templates directory")
+	}
+}
+
+func TestIsReservedPath(t *testing.T) {
+	old := reservedPaths
+	reservedPaths = map[string]bool{"index": true}
+	t.Cleanup(func() {
+		reservedPaths = old
+		fuzzyPaths = false
+	})
+
+	if !isReservedPath("INDEX") {
+		t.Errorf("reserved names should match regardless of case")
+	}
+	if isReservedPath("1ndex") {
+		t.Errorf("1ndex should only be reserved with fuzzy paths")
+	}
+	fuzzyPaths = true
+	if !isReservedPath("1ndex") {
+		t.Errorf("1ndex should be reserved with fuzzy paths")
+	}
+}
+
+func TestCreateReservedPath(t *testing.T) {
+	e := newTestServer(t)
+
+	for _, path := range []string{"captcha", "Index", "admin"} {
+		challenge, solution := fetchSolvedChallenge(t, e)
+		body, _ := json.Marshal(map[string]interface{}{
+			"url":           "http://example.com",
+			"path":          path,
+			"pow_challenge": challenge,
+			"pow_solution":  solution,
+		})
+		var response apiError
+		code := doJSON(t, e, http.MethodPost, "/api/v1/links", string(body), &response)
+		if code != http.StatusBadRequest || response.Error.Code != "path_reserved" {
+			t.Errorf("creating %s: status %d, error %+v, expected path_reserved", path, code, response.Error)
+		}
+	}
+}
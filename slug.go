@@ -0,0 +1,95 @@
+package main
+
+import (
+	"crypto/rand"
+	"math/big"
+	"strings"
+)
+
+// SlugStyle is a way of generating random paths that users can pick
+type SlugStyle struct {
+	Name     string // value used by the form and API
+	Label    string // human readable label shown in the form
+	Length   int    // length of the generated paths
+	generate func() (string, error)
+}
+
+// slugStyles lists the random path styles in the order of the form, the first one is the default
+var slugStyles = []SlugStyle{
+	{Name: "random", Label: "Letters and digits", Length: randomSlugLength, generate: func() (string, error) { return generateRandomPath(randomSlugLength) }},
+	{Name: "keypad", Label: "Easy keypad letters", Length: keypadSlugLength, generate: generateKeypadSlug},
+	{Name: "syllable", Label: "Pronounceable", Length: 2 * syllableCount, generate: generateSyllableSlug},
+	{Name: "digits", Label: "Digits only", Length: digitsSlugLength, generate: generateDigitsSlug},
+}
+
+const (
+	// randomSlugLength letters and digits give about sixty million slugs
+	randomSlugLength = 5
+
+	// keypadLetters are the letters that are first on their phone key, so every one takes a single press.
+	// Seven of them give about two million slugs.
+	keypadLetters    = "adgjmptw"
+	keypadSlugLength = 7
+
+	// syllableConsonants and syllableVowels leave out letters that are easily confused, like l and o.
+	// Three syllables give about two hundred thousand slugs.
+	syllableConsonants = "bdfghjkmnprstvz"
+	syllableVowels     = "aeiu"
+	syllableCount      = 3
+
+	// digitsSlugLength digits give ten million slugs that can be typed in numeric mode
+	digitsSlugLength = 7
+)
+
+// findSlugStyle looks up a slug style by name, falling back to the default when name is empty
+func findSlugStyle(name string) (SlugStyle, bool) {
+	if name == "" {
+		return slugStyles[0], true
+	}
+	for _, style := range slugStyles {
+		if style.Name == name {
+			return style, true
+		}
+	}
+	return SlugStyle{}, false
+}
+
+// randomChars picks length characters from charset without modulo bias
+func randomChars(charset string, length int) (string, error) {
+	var b strings.Builder
+	for i := 0; i < length; i++ {
+		n, err := rand.Int(rand.Reader, big.NewInt(int64(len(charset))))
+		if err != nil {
+			return "", err
+		}
+		b.WriteByte(charset[n.Int64()])
+	}
+	return b.String(), nil
+}
+
+// generateKeypadSlug generates a slug that takes one key press per character on a multi-tap keypad
+func generateKeypadSlug() (string, error) {
+	return randomChars(keypadLetters, keypadSlugLength)
+}
+
+// generateSyllableSlug generates a pronounceable slug of consonant-vowel syllables, e.g. "hikamu"
+func generateSyllableSlug() (string, error) {
+	var b strings.Builder
+	for i := 0; i < syllableCount; i++ {
+		consonant, err := randomChars(syllableConsonants, 1)
+		if err != nil {
+			return "", err
+		}
+		vowel, err := randomChars(syllableVowels, 1)
+		if err != nil {
+			return "", err
+		}
+		b.WriteString(consonant + vowel)
+	}
+	return b.String(), nil
+}
+
+// generateDigitsSlug generates a digits only slug for numeric entry
+func generateDigitsSlug() (string, error) {
+	return randomChars("0123456789", digitsSlugLength)
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"regexp"
+	"testing"
+	"time"
+)
+
+func TestSlugStyles(t *testing.T) {
+	patterns := map[string]*regexp.Regexp{
+		"random":   regexp.MustCompile(`^[a-z0-9]{5}$`),
+		"keypad":   regexp.MustCompile(`^[adgjmptw]{7}$`),
+		"syllable": regexp.MustCompile(`^([bdfghjkmnprstvz][aeiu]){3}$`),
+		"digits":   regexp.MustCompile(`^[0-9]{7}$`),
+	}
+
+	for _, style := range slugStyles {
+		pattern, ok := patterns[style.Name]
+		if !ok {
+			t.Errorf("no test pattern for slug style %s", style.Name)
+			continue
+		}
+		for i := 0; i < 100; i++ {
+			slug, err := style.generate()
+			if err != nil {
+				t.Fatalf("%s: generate failed: %v", style.Name, err)
+			}
+			if !pattern.MatchString(slug) || len(slug) != style.Length {
+				t.Fatalf("%s: slug %q does not match %s of length %d", style.Name, slug, pattern, style.Length)
+			}
+		}
+	}
+
+	if style, ok := findSlugStyle(""); !ok || style.Name != "random" {
+		t.Errorf("findSlugStyle(\"\") = %s, %t, expected the random style", style.Name, ok)
+	}
+	if _, ok := findSlugStyle("emoji"); ok {
+		t.Errorf("findSlugStyle accepted an unknown style")
+	}
+}
+
+func TestAPISlugStyle(t *testing.T) {
+	e := newTestServer(t)
+
+	for style, code := range map[string]string{"digits": "", "emoji": "invalid_slug_style"} {
+		challenge, solution := fetchSolvedChallenge(t, e)
+		body, _ := json.Marshal(map[string]interface{}{
+			"url":           "http://example.com",
+			"slug_style":    style,
+			"pow_challenge": challenge,
+			"pow_solution":  solution,
+		})
+
+		var response struct {
+			apiLinkResponse
+			apiError
+		}
+		doJSON(t, e, http.MethodPost, "/api/v1/links", string(body), &response)
+		if response.Error.Code != code {
+			t.Errorf("slug_style %s = error %q, expected %q", style, response.Error.Code, code)
+		}
+		if code == "" && !regexp.MustCompile(`^[0-9]{7}$`).MatchString(response.Path) {
+			t.Errorf("slug_style %s created path %q", style, response.Path)
+		}
+	}
+}
+
+// racingLinkStore lets another request take the first random path just before it is stored,
+// and the folded form of the second one
+type racingLinkStore struct {
+	LinkStore
+	taken []string
+}
+
+func (r *racingLinkStore) StoreLinkIfAbsent(ctx context.Context, path string, link Link) (bool, error) {
+	switch len(r.taken) {
+	case 0:
+		r.LinkStore.StoreLinkIfAbsent(ctx, path, Link{URL: "http://other.example"})
+	case 1:
+		r.LinkStore.StoreLinkIfAbsent(ctx, "rival", Link{URL: "http://other.example"})
+		r.LinkStore.ClaimPathFold(ctx, foldPath(path), "rival", time.Time{})
+	}
+	r.taken = append(r.taken, path)
+	return r.LinkStore.StoreLinkIfAbsent(ctx, path, link)
+}
+
+func TestRandomPathRace(t *testing.T) {
+	e := newTestServer(t)
+	fuzzyPaths = true
+	t.Cleanup(func() { fuzzyPaths = false })
+	racing := &racingLinkStore{LinkStore: linkStore}
+	linkStore = racing
+
+	challenge, solution := fetchSolvedChallenge(t, e)
+	body, _ := json.Marshal(map[string]interface{}{
+		"url":           "http://example.com",
+		"pow_challenge": challenge,
+		"pow_solution":  solution,
+	})
+	var response struct {
+		apiLinkResponse
+		apiError
+	}
+	if code := doJSON(t, e, http.MethodPost, "/api/v1/links", string(body), &response); code != http.StatusCreated {
+		t.Fatalf("POST /api/v1/links = %d %q, expected a new link despite the races", code, response.Error.Code)
+	}
+	if len(racing.taken) != 3 || response.Path != racing.taken[2] {
+		t.Errorf("created %q after trying %v, expected the third path", response.Path, racing.taken)
+	}
+	if link, _, _ := linkStore.GetLink(context.Background(), response.Path); link.URL != "http://example.com" {
+		t.Errorf("link at %s goes to %q", response.Path, link.URL)
+	}
+}
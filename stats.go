@@ -0,0 +1,95 @@
+package main
+
+import (
+	"crypto/subtle"
+	"html/template"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// statsDays is the number of days shown on the stats page
+const statsDays = 14
+
+// statsToken unlocks the stats page of every link, without it only link owners see their stats
+var statsToken string
+
+// StatsCount is a labelled click count on the stats page
+type StatsCount struct {
+	Label string
+	Count int64
+}
+
+// StatsData holds data for rendering the stats templates
+type StatsData struct {
+	ShortURL string
+	URL      string
+	Total    int64
+	Classes  []StatsCount
+	Days     []StatsCount // newest first
+}
+
+// newStatsData lays out the click stats of a link for the stats page
+func newStatsData(path string, link Link, stats ClickStats, now time.Time) StatsData {
+	data := StatsData{
+		ShortURL: shortURLPrefix + path,
+		URL:      link.URL,
+		Total:    stats.Total,
+	}
+	for _, class := range clientClasses {
+		data.Classes = append(data.Classes, StatsCount{Label: class, Count: stats.Classes[class]})
+	}
+	for i := 0; i < statsDays; i++ {
+		day := now.UTC().AddDate(0, 0, -i).Format(clickDayFormat)
+		data.Days = append(data.Days, StatsCount{Label: day, Count: stats.Days[day]})
+	}
+	return data
+}
+
+// canViewStats reports whether the request carries the management token of link or the token that unlocks all stats
+func canViewStats(c echo.Context, link Link) bool {
+	token := c.QueryParam("token")
+	if ownsLink(link, token) {
+		return true
+	}
+	return statsToken != "" && subtle.ConstantTimeCompare([]byte(token), []byte(statsToken)) == 1
+}
+
+// handleStats shows the click stats of a short link to its owner
+func handleStats(c echo.Context) error {
+	path := c.Param("path")
+
+	if !pathPolicy.Allows(path) {
+		return serve404(c)
+	}
+
+	ctx, cancel := storageContext(c)
+	defer cancel()
+
+	link, exists, err := linkStore.GetLink(ctx, path)
+	if err != nil {
+		log.Printf("Failed to retrieve URL mapping for %s: %v", path, err)
+		return serveStorageError(c, err)
+	}
+	if !exists || !canViewStats(c, link) {
+		return serve404(c)
+	}
+
+	stats, err := linkStore.GetClickStats(ctx, path)
+	if err != nil {
+		log.Printf("Failed to retrieve click stats for %s: %v", path, err)
+		return serveStorageError(c, err)
+	}
+
+	data := newStatsData(path, link, stats, time.Now())
+	if acceptsWML(c) {
+		return renderWML(c, http.StatusOK, "stats.wml", data)
+	}
+
+	tmpl := template.Must(template.ParseFiles("./templates/stats.html"))
+	c.Response().Header().Set("Content-Type", "text/html")
+	c.Response().WriteHeader(http.StatusOK)
+	return tmpl.Execute(c.Response().Writer, data)
+}
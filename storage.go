@@ -5,191 +5,683 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/redis/go-redis/v9"
 )
 
-// ChallengeStorage interface defines the methods for storing and retrieving challenges
-type ChallengeStorage interface {
-	Store(challenge string, solved bool) error
-	Get(challenge string) (bool, bool, error) // returns (solved, exists, error)
-	StoreURL(path string, fullURL string) error
-	GetURL(path string) (string, bool, error) // returns (fullURL, exists, error)
+// ChallengeStore tracks spent proof of work challenges. Its state is short lived and only needs
+// to outlive challengeTTL.
+type ChallengeStore interface {
+	MarkSpent(ctx context.Context, id string, ttl time.Duration) (bool, error) // returns (marked, error)
+}
+
+// LinkStore keeps short links, the folded path index and click stats.
+// Links expire at their ExpiresAt time, or never if it is zero.
+type LinkStore interface {
+	StoreLinkIfAbsent(ctx context.Context, path string, link Link) (bool, error)                 // returns (stored, error)
+	GetLink(ctx context.Context, path string) (Link, bool, error)                                // returns (link, exists, error)
+	UpdateLink(ctx context.Context, path string, link Link) (bool, error)                        // replaces an existing link, keeping its stats; returns (updated, error)
+	DeleteLink(ctx context.Context, path string) (bool, error)                                   // removes a link and its stats; returns (deleted, error)
+	ClaimPathFold(ctx context.Context, folded, path string, expiresAt time.Time) (string, error) // claims or refreshes a folded path, returns its owner
+	GetPathFold(ctx context.Context, folded string) (string, bool, error)                        // returns (path, exists, error)
+	ReleasePathFold(ctx context.Context, folded, path string) error                              // removes the folded path if path owns it
+	RecordClick(ctx context.Context, path string, click Click) error                             // counts a click on the link at path
+	GetClickStats(ctx context.Context, path string) (ClickStats, error)                          // storing a link resets its stats
+}
+
+// StorageBackend is a backend that can hold both challenges and links
+type StorageBackend interface {
+	ChallengeStore
+	LinkStore
 	Close() error
 }
 
-// RedisStorage implements ChallengeStorage using Redis/Valkey
+// RedisStorage implements StorageBackend using Redis/Valkey, on a single node,
+// behind Sentinel or in a cluster
 type RedisStorage struct {
-	client *redis.Client
-	ctx    context.Context
+	client    redis.UniversalClient
+	closeOnce sync.Once
 }
 
 // NewRedisStorage creates a new Redis storage instance
-func NewRedisStorage(addr, password string, db int) (*RedisStorage, error) {
-	if addr == "" {
-		addr = "localhost:6379"
-	}
-
-	rdb := redis.NewClient(&redis.Options{
-		Addr:     addr,
-		Password: password,
-		DB:       db,
-	})
-
-	ctx := context.Background()
+func NewRedisStorage(config RedisConfig) (*RedisStorage, error) {
+	rdb := redis.NewUniversalClient(&config.Options)
 
 	// Test connection
-	_, err := rdb.Ping(ctx).Result()
+	_, err := rdb.Ping(context.Background()).Result()
 	if err != nil {
+		rdb.Close()
 		return nil, fmt.Errorf("failed to connect to Redis: %w", err)
 	}
 
 	return &RedisStorage{
 		client: rdb,
-		ctx:    ctx,
 	}, nil
 }
 
-// Store stores a challenge with its solved status in Redis
-func (r *RedisStorage) Store(challenge string, solved bool) error {
-	key := fmt.Sprintf("challenge:%s", challenge)
-	value := "0"
-	if solved {
-		value = "1"
+// redisLinkKey is the key of the link at path. Paths never contain braces,
+// so the whole key decides its cluster slot.
+func redisLinkKey(path string) string {
+	return "url:" + path
+}
+
+// redisClicksKey is the key of the click stats of the link at path. Its hash tag is the
+// link key, which keeps both in one cluster slot for the transactions and scripts using them.
+func redisClicksKey(path string) string {
+	return "clicks:{" + redisLinkKey(path) + "}"
+}
+
+// MarkSpent atomically records a challenge ID as spent in Redis.
+// Returns false if it was already spent.
+func (r *RedisStorage) MarkSpent(ctx context.Context, id string, ttl time.Duration) (bool, error) {
+	key := fmt.Sprintf("spent:%s", id)
+
+	marked, err := r.client.SetNX(ctx, key, "1", ttl).Result()
+	if err != nil {
+		return false, fmt.Errorf("failed to mark challenge as spent in Redis: %w", err)
 	}
 
-	// Set with 24-hour expiration
-	err := r.client.Set(r.ctx, key, value, 24*time.Hour).Err()
+	return marked, nil
+}
+
+// Close closes the Redis connection
+func (r *RedisStorage) Close() error {
+	var err error
+	r.closeOnce.Do(func() {
+		err = r.client.Close()
+	})
+	return err
+}
+
+// StoreLinkIfAbsent atomically stores a link in Redis unless the path is already taken
+func (r *RedisStorage) StoreLinkIfAbsent(ctx context.Context, path string, link Link) (bool, error) {
+	key := redisLinkKey(path)
+
+	value, err := encodeLink(link)
 	if err != nil {
-		return fmt.Errorf("failed to store challenge in Redis: %w", err)
+		return false, fmt.Errorf("failed to encode link: %w", err)
 	}
 
-	return nil
+	err = r.client.SetArgs(ctx, key, value, redis.SetArgs{Mode: "NX", ExpireAt: link.ExpiresAt}).Err()
+	if err == redis.Nil {
+		return false, nil // Path is already taken
+	} else if err != nil {
+		return false, fmt.Errorf("failed to store URL in Redis: %w", err)
+	}
+
+	// Reset the stats left behind by an expired link on the same path
+	if err := r.client.Del(ctx, redisClicksKey(path)).Err(); err != nil {
+		return true, fmt.Errorf("failed to reset click stats in Redis: %w", err)
+	}
+
+	return true, nil
 }
 
-// Get retrieves a challenge's solved status from Redis
-func (r *RedisStorage) Get(challenge string) (bool, bool, error) {
-	key := fmt.Sprintf("challenge:%s", challenge)
+// GetLink retrieves a link from Redis
+func (r *RedisStorage) GetLink(ctx context.Context, path string) (Link, bool, error) {
+	key := redisLinkKey(path)
 
-	val, err := r.client.Get(r.ctx, key).Result()
+	val, err := r.client.Get(ctx, key).Result()
 	if err == redis.Nil {
-		return false, false, nil // Key doesn't exist
+		return Link{}, false, nil // Key doesn't exist
 	} else if err != nil {
-		return false, false, fmt.Errorf("failed to get challenge from Redis: %w", err)
+		return Link{}, false, fmt.Errorf("failed to get URL from Redis: %w", err)
+	}
+
+	link, err := decodeLink(val)
+	if err != nil {
+		return Link{}, false, fmt.Errorf("failed to decode link %s from Redis: %w", path, err)
 	}
 
-	solved := val == "1"
-	return solved, true, nil
+	// Legacy links only expire through the TTL of their key, which is -1 when there is none
+	if isLegacyLinkValue(val) {
+		ttl, err := r.client.PTTL(ctx, key).Result()
+		if err != nil {
+			return Link{}, false, fmt.Errorf("failed to get URL expiry from Redis: %w", err)
+		}
+		if ttl > 0 {
+			link.ExpiresAt = time.Now().Add(ttl)
+		}
+	}
+
+	return link, true, nil
 }
 
-// Close closes the Redis connection
-func (r *RedisStorage) Close() error {
-	return r.client.Close()
+// UpdateLink replaces an existing link in Redis, moving the expiry of its stats along
+func (r *RedisStorage) UpdateLink(ctx context.Context, path string, link Link) (bool, error) {
+	key := redisLinkKey(path)
+
+	value, err := encodeLink(link)
+	if err != nil {
+		return false, fmt.Errorf("failed to encode link: %w", err)
+	}
+
+	// Setting a key without expiry removes its TTL, which makes the link permanent
+	err = r.client.SetArgs(ctx, key, value, redis.SetArgs{Mode: "XX", ExpireAt: link.ExpiresAt}).Err()
+	if err == redis.Nil {
+		return false, nil // Link doesn't exist
+	} else if err != nil {
+		return false, fmt.Errorf("failed to update URL in Redis: %w", err)
+	}
+
+	clicksKey := redisClicksKey(path)
+	if link.ExpiresAt.IsZero() {
+		err = r.client.Persist(ctx, clicksKey).Err()
+	} else {
+		err = r.client.ExpireAt(ctx, clicksKey, link.ExpiresAt).Err()
+	}
+	if err != nil {
+		return true, fmt.Errorf("failed to update click stats expiry in Redis: %w", err)
+	}
+
+	return true, nil
+}
+
+// DeleteLink removes a link and its click stats from Redis
+func (r *RedisStorage) DeleteLink(ctx context.Context, path string) (bool, error) {
+	var deleted *redis.IntCmd
+	_, err := r.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		deleted = pipe.Del(ctx, redisLinkKey(path))
+		pipe.Del(ctx, redisClicksKey(path))
+		return nil
+	})
+	if err != nil {
+		return false, fmt.Errorf("failed to delete URL from Redis: %w", err)
+	}
+
+	return deleted.Val() > 0, nil
 }
 
-// StoreURL stores a URL mapping in Redis
-func (r *RedisStorage) StoreURL(path string, fullURL string) error {
-	key := fmt.Sprintf("url:%s", path)
+// claimPathFoldScript sets fold:<folded> to the path unless another path owns it,
+// with an expiry in unix milliseconds or 0 to keep it forever
+var claimPathFoldScript = redis.NewScript(`
+local owner = redis.call("GET", KEYS[1])
+if owner and owner ~= ARGV[1] then
+	return owner
+end
+if ARGV[2] == "0" then
+	redis.call("SET", KEYS[1], ARGV[1])
+else
+	redis.call("SET", KEYS[1], ARGV[1], "PXAT", ARGV[2])
+end
+return ARGV[1]
+`)
+
+// releasePathFoldScript deletes fold:<folded> only if the path owns it
+var releasePathFoldScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("DEL", KEYS[1])
+end
+return 0
+`)
+
+// ClaimPathFold atomically claims fold:<folded> for path in Redis, or refreshes its expiry if path already owns it
+func (r *RedisStorage) ClaimPathFold(ctx context.Context, folded, path string, expiresAt time.Time) (string, error) {
+	key := fmt.Sprintf("fold:%s", folded)
+
+	var expiresAtMillis int64
+	if !expiresAt.IsZero() {
+		expiresAtMillis = expiresAt.UnixMilli()
+	}
 
-	// Set with 24-hour expiration (same as challenges)
-	err := r.client.Set(r.ctx, key, fullURL, 24*time.Hour).Err()
+	owner, err := claimPathFoldScript.Run(ctx, r.client, []string{key}, path, expiresAtMillis).Text()
 	if err != nil {
-		return fmt.Errorf("failed to store URL in Redis: %w", err)
+		return "", fmt.Errorf("failed to claim folded path in Redis: %w", err)
 	}
 
-	return nil
+	return owner, nil
 }
 
-// GetURL retrieves a URL mapping from Redis
-func (r *RedisStorage) GetURL(path string) (string, bool, error) {
-	key := fmt.Sprintf("url:%s", path)
+// GetPathFold retrieves the path owning a folded path from Redis
+func (r *RedisStorage) GetPathFold(ctx context.Context, folded string) (string, bool, error) {
+	key := fmt.Sprintf("fold:%s", folded)
 
-	val, err := r.client.Get(r.ctx, key).Result()
+	path, err := r.client.Get(ctx, key).Result()
 	if err == redis.Nil {
-		return "", false, nil // Key doesn't exist
+		return "", false, nil
 	} else if err != nil {
-		return "", false, fmt.Errorf("failed to get URL from Redis: %w", err)
+		return "", false, fmt.Errorf("failed to get folded path from Redis: %w", err)
 	}
 
-	return val, true, nil
+	return path, true, nil
 }
 
-// LocalMapStorage implements ChallengeStorage using an in-memory map
+// ReleasePathFold atomically removes fold:<folded> from Redis if path owns it
+func (r *RedisStorage) ReleasePathFold(ctx context.Context, folded, path string) error {
+	key := fmt.Sprintf("fold:%s", folded)
+
+	if err := releasePathFoldScript.Run(ctx, r.client, []string{key}, path).Err(); err != nil {
+		return fmt.Errorf("failed to release folded path in Redis: %w", err)
+	}
+
+	return nil
+}
+
+// recordClickScript counts a click in the clicks hash unless the link is gone,
+// with the expiry of the link in unix milliseconds or 0 to keep the stats forever
+var recordClickScript = redis.NewScript(`
+if redis.call("EXISTS", KEYS[1]) == 0 then
+	return 0
+end
+redis.call("HINCRBY", KEYS[2], "total", 1)
+redis.call("HINCRBY", KEYS[2], ARGV[1], 1)
+redis.call("HINCRBY", KEYS[2], ARGV[2], 1)
+if ARGV[3] ~= "0" then
+	redis.call("PEXPIREAT", KEYS[2], ARGV[3])
+end
+return 1
+`)
+
+// RecordClick counts a click in the clicks hash of a link, which has a total,
+// a class:<class> field per client class and a day:<day> field per day
+func (r *RedisStorage) RecordClick(ctx context.Context, path string, click Click) error {
+	keys := []string{redisLinkKey(path), redisClicksKey(path)}
+
+	var expiresAtMillis int64
+	if !click.ExpiresAt.IsZero() {
+		expiresAtMillis = click.ExpiresAt.UnixMilli()
+	}
+
+	// Clicks racing with the expiry of their link are not worth keeping
+	err := recordClickScript.Run(ctx, r.client, keys, "class:"+click.Class, "day:"+click.At.UTC().Format(clickDayFormat), expiresAtMillis).Err()
+	if err != nil {
+		return fmt.Errorf("failed to record click in Redis: %w", err)
+	}
+
+	return nil
+}
+
+// GetClickStats retrieves the click stats of a link from Redis
+func (r *RedisStorage) GetClickStats(ctx context.Context, path string) (ClickStats, error) {
+	key := redisClicksKey(path)
+
+	fields, err := r.client.HGetAll(ctx, key).Result()
+	if err != nil {
+		return ClickStats{}, fmt.Errorf("failed to get click stats from Redis: %w", err)
+	}
+
+	stats := newClickStats()
+	for field, value := range fields {
+		count, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return ClickStats{}, fmt.Errorf("invalid click count %s in Redis: %w", field, err)
+		}
+		if field == "total" {
+			stats.Total = count
+		} else if class, ok := strings.CutPrefix(field, "class:"); ok {
+			stats.Classes[class] = count
+		} else if day, ok := strings.CutPrefix(field, "day:"); ok {
+			stats.Days[day] = count
+		}
+	}
+
+	return stats, nil
+}
+
+// localSweepInterval is how often expired entries are removed from the local map
+const localSweepInterval = time.Minute
+
+// localFold is the path owning a folded path, with its expiry time
+type localFold struct {
+	path      string
+	expiresAt time.Time
+}
+
+// isExpired reports whether an entry with the given expiry time is expired at now.
+// The zero time never expires.
+func isExpired(expiresAt time.Time, now time.Time) bool {
+	return !expiresAt.IsZero() && !now.Before(expiresAt)
+}
+
+// LocalMapStorage implements StorageBackend using an in-memory map.
+// Entries expire like they do in Redis and are swept by a background goroutine.
 type LocalMapStorage struct {
-	challenges map[string]bool
-	urls       map[string]string
-	mu         sync.RWMutex
+	spent     map[string]time.Time // challenge ID to expiry time
+	links     map[string]Link
+	folds     map[string]localFold
+	clicks    map[string]*ClickStats
+	mu        sync.RWMutex
+	now       func() time.Time
+	stop      chan struct{}
+	closeOnce sync.Once
 }
 
 // NewLocalMapStorage creates a new local map storage instance
 func NewLocalMapStorage() *LocalMapStorage {
-	return &LocalMapStorage{
-		challenges: make(map[string]bool),
-		urls:       make(map[string]string),
+	l := &LocalMapStorage{
+		spent:  make(map[string]time.Time),
+		links:  make(map[string]Link),
+		folds:  make(map[string]localFold),
+		clicks: make(map[string]*ClickStats),
+		now:    time.Now,
+		stop:   make(chan struct{}),
 	}
+	go l.sweep()
+
+	return l
 }
 
-// Store stores a challenge with its solved status in the local map
-func (l *LocalMapStorage) Store(challenge string, solved bool) error {
+// MarkSpent records a challenge ID as spent in the local map.
+// Returns false if it was already spent.
+func (l *LocalMapStorage) MarkSpent(ctx context.Context, id string, ttl time.Duration) (bool, error) {
 	l.mu.Lock()
 	defer l.mu.Unlock()
 
-	l.challenges[challenge] = solved
+	now := l.now()
+	if expiresAt, exists := l.spent[id]; exists && now.Before(expiresAt) {
+		return false, nil
+	}
+	l.spent[id] = now.Add(ttl)
+	return true, nil
+}
+
+// Close stops the sweeper of the local map storage
+func (l *LocalMapStorage) Close() error {
+	l.closeOnce.Do(func() {
+		close(l.stop)
+	})
 	return nil
 }
 
-// Get retrieves a challenge's solved status from the local map
-func (l *LocalMapStorage) Get(challenge string) (bool, bool, error) {
+// StoreLinkIfAbsent stores a link in the local map unless the path is already taken
+func (l *LocalMapStorage) StoreLinkIfAbsent(ctx context.Context, path string, link Link) (bool, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if existing, exists := l.links[path]; exists && !isExpired(existing.ExpiresAt, l.now()) {
+		return false, nil
+	}
+	l.links[path] = link
+	delete(l.clicks, path)
+	return true, nil
+}
+
+// GetLink retrieves a link from the local map
+func (l *LocalMapStorage) GetLink(ctx context.Context, path string) (Link, bool, error) {
 	l.mu.RLock()
 	defer l.mu.RUnlock()
 
-	solved, exists := l.challenges[challenge]
-	return solved, exists, nil
+	link, exists := l.links[path]
+	if !exists || isExpired(link.ExpiresAt, l.now()) {
+		return Link{}, false, nil
+	}
+	return link, true, nil
 }
 
-// Close is a no-op for local map storage
-func (l *LocalMapStorage) Close() error {
+// UpdateLink replaces an existing link in the local map, keeping its stats
+func (l *LocalMapStorage) UpdateLink(ctx context.Context, path string, link Link) (bool, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if existing, exists := l.links[path]; !exists || isExpired(existing.ExpiresAt, l.now()) {
+		return false, nil
+	}
+	l.links[path] = link
+	return true, nil
+}
+
+// DeleteLink removes a link and its stats from the local map
+func (l *LocalMapStorage) DeleteLink(ctx context.Context, path string) (bool, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	existing, exists := l.links[path]
+	delete(l.links, path)
+	delete(l.clicks, path)
+	return exists && !isExpired(existing.ExpiresAt, l.now()), nil
+}
+
+// ClaimPathFold claims a folded path in the local map, or refreshes its expiry if path already owns it
+func (l *LocalMapStorage) ClaimPathFold(ctx context.Context, folded, path string, expiresAt time.Time) (string, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if existing, exists := l.folds[folded]; exists && existing.path != path && !isExpired(existing.expiresAt, l.now()) {
+		return existing.path, nil
+	}
+	l.folds[folded] = localFold{path: path, expiresAt: expiresAt}
+	return path, nil
+}
+
+// GetPathFold retrieves the path owning a folded path from the local map
+func (l *LocalMapStorage) GetPathFold(ctx context.Context, folded string) (string, bool, error) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	fold, exists := l.folds[folded]
+	if !exists || isExpired(fold.expiresAt, l.now()) {
+		return "", false, nil
+	}
+	return fold.path, true, nil
+}
+
+// ReleasePathFold removes a folded path from the local map if path owns it
+func (l *LocalMapStorage) ReleasePathFold(ctx context.Context, folded, path string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if fold, exists := l.folds[folded]; exists && fold.path == path {
+		delete(l.folds, folded)
+	}
 	return nil
 }
 
-// StoreURL stores a URL mapping in the local map
-func (l *LocalMapStorage) StoreURL(path string, fullURL string) error {
+// RecordClick counts a click on a link in the local map
+func (l *LocalMapStorage) RecordClick(ctx context.Context, path string, click Click) error {
 	l.mu.Lock()
 	defer l.mu.Unlock()
 
-	l.urls[path] = fullURL
+	// Clicks racing with the expiry of their link are not worth keeping
+	if link, exists := l.links[path]; !exists || isExpired(link.ExpiresAt, l.now()) {
+		return nil
+	}
+
+	stats, exists := l.clicks[path]
+	if !exists {
+		newStats := newClickStats()
+		stats = &newStats
+		l.clicks[path] = stats
+	}
+	stats.add(click)
 	return nil
 }
 
-// GetURL retrieves a URL mapping from the local map
-func (l *LocalMapStorage) GetURL(path string) (string, bool, error) {
+// GetClickStats retrieves a copy of the click stats of a link from the local map
+func (l *LocalMapStorage) GetClickStats(ctx context.Context, path string) (ClickStats, error) {
 	l.mu.RLock()
 	defer l.mu.RUnlock()
 
-	fullURL, exists := l.urls[path]
-	return fullURL, exists, nil
+	stats := newClickStats()
+	if existing, exists := l.clicks[path]; exists {
+		stats.Total = existing.Total
+		for class, count := range existing.Classes {
+			stats.Classes[class] = count
+		}
+		for day, count := range existing.Days {
+			stats.Days[day] = count
+		}
+	}
+	return stats, nil
 }
 
-// NewChallengeStorage creates a new challenge storage instance based on environment
-func NewChallengeStorage() ChallengeStorage {
-	// Check if we should use Redis
-	redisAddr := os.Getenv("REDIS_ADDR")
-	redisPassword := os.Getenv("REDIS_PASSWORD")
-	useRedis := os.Getenv("USE_REDIS")
+// sweep periodically removes expired entries until the storage is closed
+func (l *LocalMapStorage) sweep() {
+	ticker := time.NewTicker(localSweepInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-l.stop:
+			return
+		case <-ticker.C:
+			l.deleteExpired()
+		}
+	}
+}
 
-	// Use Redis if explicitly enabled or if running in production
-	if useRedis == "true" || os.Getenv("ENV") == "production" {
-		redisStorage, err := NewRedisStorage(redisAddr, redisPassword, 0)
+// deleteExpired removes all expired entries from the local map
+func (l *LocalMapStorage) deleteExpired() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	for id, expiresAt := range l.spent {
+		if !now.Before(expiresAt) {
+			delete(l.spent, id)
+		}
+	}
+	for path, link := range l.links {
+		if isExpired(link.ExpiresAt, now) {
+			delete(l.links, path)
+			delete(l.clicks, path)
+		}
+	}
+	for folded, fold := range l.folds {
+		if isExpired(fold.expiresAt, now) {
+			delete(l.folds, folded)
+		}
+	}
+}
+
+// Storage backends that can be picked with CHALLENGE_STORAGE and LINK_STORAGE
+const (
+	storageRedis  = "redis"
+	storageBolt   = "bolt"
+	storageMemory = "memory"
+)
+
+// Storage holds the store of each concern. Challenges and links can live in different
+// backends, e.g. challenges in Redis and links in bolt, and concerns configured with the
+// same kind of backend share one instance of it.
+type Storage struct {
+	Challenges ChallengeStore
+	Links      LinkStore
+	backends   map[string]StorageBackend
+	fallbacks  map[string]error // why a kind was replaced by a local backend
+}
+
+// NewStorage opens the backends configured in CHALLENGE_STORAGE and LINK_STORAGE, which
+// must open. Without them everything goes to Redis when USE_REDIS is set or in production,
+// otherwise to bolt when BOLT_PATH is set or to memory, falling back to local storage
+// when Redis or bolt can't be opened.
+func NewStorage() (*Storage, error) {
+	s := &Storage{
+		backends:  make(map[string]StorageBackend),
+		fallbacks: make(map[string]error),
+	}
+
+	challenges, err := s.open(storageKind(os.Getenv("CHALLENGE_STORAGE")))
+	if err != nil {
+		return nil, fmt.Errorf("invalid CHALLENGE_STORAGE: %w", err)
+	}
+	links, err := s.open(storageKind(os.Getenv("LINK_STORAGE")))
+	if err != nil {
+		s.Close()
+		return nil, fmt.Errorf("invalid LINK_STORAGE: %w", err)
+	}
+
+	s.Challenges = challenges
+	s.Links = links
+	return s, nil
+}
+
+// storageKind returns the configured backend kind, or the default one if kind is empty,
+// and whether the kind was configured explicitly
+func storageKind(kind string) (string, bool) {
+	if kind = strings.ToLower(strings.TrimSpace(kind)); kind != "" {
+		return kind, true
+	}
+	if os.Getenv("USE_REDIS") == "true" || os.Getenv("ENV") == "production" {
+		return storageRedis, false
+	}
+	return localStorageKind(), false
+}
+
+// localStorageKind is the backend used without Redis: a bolt database file when BOLT_PATH
+// is set, so links survive restarts, or an in-memory map otherwise
+func localStorageKind() string {
+	if os.Getenv("BOLT_PATH") != "" {
+		return storageBolt
+	}
+	return storageMemory
+}
+
+// open returns the backend of the given kind, opening it on first use. A default backend
+// that can't be opened falls back to the next local one, an explicitly configured one
+// returns an error instead of quietly losing data on the next restart.
+func (s *Storage) open(kind string, explicit bool) (StorageBackend, error) {
+	if err, ok := s.fallbacks[kind]; ok && explicit {
+		return nil, err
+	}
+	if backend, ok := s.backends[kind]; ok {
+		return backend, nil
+	}
+
+	var backend StorageBackend
+	switch kind {
+	case storageRedis:
+		config, err := redisConfigFromEnv()
 		if err != nil {
-			log.Printf("Failed to initialize Redis storage: %v. Falling back to local storage.", err)
-			return NewLocalMapStorage()
+			return nil, err
 		}
-		log.Println("Using Redis storage for challenges")
-		return redisStorage
+		redisStorage, err := NewRedisStorage(config)
+		if err != nil {
+			return s.fallback(kind, explicit, localStorageKind(), fmt.Errorf("failed to initialize Redis storage: %w", err))
+		}
+		log.Printf("Using Redis storage on %s", config)
+		backend = redisStorage
+	case storageBolt:
+		boltPath := os.Getenv("BOLT_PATH")
+		boltStorage, err := NewBoltStorage(boltPath)
+		if err != nil {
+			return s.fallback(kind, explicit, storageMemory, fmt.Errorf("failed to initialize bolt storage: %w", err))
+		}
+		log.Printf("Using bolt storage in %s", boltPath)
+		backend = boltStorage
+	case storageMemory:
+		log.Println("Using local map storage")
+		backend = NewLocalMapStorage()
+	default:
+		return nil, fmt.Errorf("unknown storage %q, expected %s, %s or %s", kind, storageRedis, storageBolt, storageMemory)
+	}
+
+	s.backends[kind] = backend
+	return backend, nil
+}
+
+// fallback opens the backend of kind other in place of kind, which failed to open with err.
+// Explicitly configured kinds don't fall back and return err.
+func (s *Storage) fallback(kind string, explicit bool, other string, err error) (StorageBackend, error) {
+	if explicit {
+		return nil, err
 	}
+	log.Printf("%v. Falling back to %s storage.", err, other)
 
-	log.Println("Using local map storage for challenges")
-	return NewLocalMapStorage()
+	backend, otherErr := s.open(other, false)
+	if otherErr != nil {
+		return nil, otherErr
+	}
+	s.backends[kind] = backend
+	s.fallbacks[kind] = err
+	return backend, nil
+}
+
+// Close closes every opened backend once
+func (s *Storage) Close() error {
+	var firstErr error
+	closed := make(map[StorageBackend]bool)
+	for _, backend := range s.backends {
+		if closed[backend] {
+			continue
+		}
+		closed[backend] = true
+		if err := backend.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
 }
@@ -0,0 +1,406 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+var (
+	boltSpentBucket  = []byte("spent")
+	boltURLsBucket   = []byte("urls")
+	boltClicksBucket = []byte("clicks")
+	boltFoldsBucket  = []byte("folds")
+)
+
+// boltSweepInterval is how often expired entries are removed from the database file
+const boltSweepInterval = 10 * time.Minute
+
+// BoltStorage implements StorageBackend using an embedded bbolt database file.
+// Every write is a bbolt transaction, which is fsynced before it returns.
+type BoltStorage struct {
+	db        *bolt.DB
+	now       func() time.Time
+	stop      chan struct{}
+	closeOnce sync.Once
+}
+
+// NewBoltStorage opens (or creates) the bbolt database at path
+func NewBoltStorage(path string) (*BoltStorage, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("failed to open bolt database %s: %w", path, err)
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, bucket := range [][]byte{boltSpentBucket, boltURLsBucket, boltClicksBucket, boltFoldsBucket} {
+			if _, err := tx.CreateBucketIfNotExists(bucket); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to create bolt buckets: %w", err)
+	}
+
+	b := &BoltStorage{
+		db:   db,
+		now:  time.Now,
+		stop: make(chan struct{}),
+	}
+	go b.sweep()
+
+	return b, nil
+}
+
+// encodeBoltValue prefixes a value with its expiry time, stored as 0 if it never expires
+func encodeBoltValue(value string, expiresAt time.Time) []byte {
+	buf := make([]byte, 8+len(value))
+	if !expiresAt.IsZero() {
+		binary.BigEndian.PutUint64(buf, uint64(expiresAt.UnixNano()))
+	}
+	copy(buf[8:], value)
+	return buf
+}
+
+// decodeBoltValue splits a stored value from its expiry time
+func decodeBoltValue(buf []byte) (string, time.Time, error) {
+	if len(buf) < 8 {
+		return "", time.Time{}, fmt.Errorf("corrupt bolt value of %d bytes", len(buf))
+	}
+	var expiresAt time.Time
+	if nanos := binary.BigEndian.Uint64(buf); nanos != 0 {
+		expiresAt = time.Unix(0, int64(nanos))
+	}
+	return string(buf[8:]), expiresAt, nil
+}
+
+// get retrieves a value, treating expired entries as missing
+func (b *BoltStorage) get(bucket []byte, key string) (string, bool, error) {
+	var value string
+	var exists bool
+	err := b.db.View(func(tx *bolt.Tx) error {
+		buf := tx.Bucket(bucket).Get([]byte(key))
+		if buf == nil {
+			return nil
+		}
+		v, expiresAt, err := decodeBoltValue(buf)
+		if err != nil {
+			return err
+		}
+		if !isExpired(expiresAt, b.now()) {
+			value, exists = v, true
+		}
+		return nil
+	})
+	return value, exists, err
+}
+
+// MarkSpent records a challenge ID as spent in the database.
+// Returns false if it was already spent.
+func (b *BoltStorage) MarkSpent(ctx context.Context, id string, ttl time.Duration) (bool, error) {
+	marked := false
+	err := b.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(boltSpentBucket)
+		if buf := bucket.Get([]byte(id)); buf != nil {
+			_, expiresAt, err := decodeBoltValue(buf)
+			if err != nil {
+				return err
+			}
+			if !isExpired(expiresAt, b.now()) {
+				return nil
+			}
+		}
+		marked = true
+		return bucket.Put([]byte(id), encodeBoltValue("", b.now().Add(ttl)))
+	})
+	if err != nil {
+		return false, fmt.Errorf("failed to mark challenge as spent in bolt: %w", err)
+	}
+	return marked, nil
+}
+
+// StoreLinkIfAbsent stores a link in the database unless the path is already taken.
+// Bolt serializes write transactions, so the check and the write are atomic.
+func (b *BoltStorage) StoreLinkIfAbsent(ctx context.Context, path string, link Link) (bool, error) {
+	value, err := encodeLink(link)
+	if err != nil {
+		return false, fmt.Errorf("failed to encode link: %w", err)
+	}
+
+	stored := false
+	err = b.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(boltURLsBucket)
+		if buf := bucket.Get([]byte(path)); buf != nil {
+			_, expiresAt, err := decodeBoltValue(buf)
+			if err != nil {
+				return err
+			}
+			if !isExpired(expiresAt, b.now()) {
+				return nil
+			}
+		}
+		stored = true
+		if err := tx.Bucket(boltClicksBucket).Delete([]byte(path)); err != nil {
+			return err
+		}
+		return bucket.Put([]byte(path), encodeBoltValue(value, link.ExpiresAt))
+	})
+	if err != nil {
+		return false, fmt.Errorf("failed to store URL in bolt: %w", err)
+	}
+	return stored, nil
+}
+
+// GetLink retrieves a link from the database
+func (b *BoltStorage) GetLink(ctx context.Context, path string) (Link, bool, error) {
+	value, exists, err := b.get(boltURLsBucket, path)
+	if err != nil {
+		return Link{}, false, fmt.Errorf("failed to get URL from bolt: %w", err)
+	}
+	if !exists {
+		return Link{}, false, nil
+	}
+
+	link, err := decodeLink(value)
+	if err != nil {
+		return Link{}, false, fmt.Errorf("failed to decode link %s from bolt: %w", path, err)
+	}
+	return link, true, nil
+}
+
+// UpdateLink replaces an existing link in the database, keeping its stats
+func (b *BoltStorage) UpdateLink(ctx context.Context, path string, link Link) (bool, error) {
+	value, err := encodeLink(link)
+	if err != nil {
+		return false, fmt.Errorf("failed to encode link: %w", err)
+	}
+
+	updated := false
+	err = b.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(boltURLsBucket)
+		buf := bucket.Get([]byte(path))
+		if buf == nil {
+			return nil
+		}
+		_, expiresAt, err := decodeBoltValue(buf)
+		if err != nil {
+			return err
+		}
+		if isExpired(expiresAt, b.now()) {
+			return nil
+		}
+		updated = true
+		return bucket.Put([]byte(path), encodeBoltValue(value, link.ExpiresAt))
+	})
+	if err != nil {
+		return false, fmt.Errorf("failed to update URL in bolt: %w", err)
+	}
+	return updated, nil
+}
+
+// DeleteLink removes a link and its stats from the database
+func (b *BoltStorage) DeleteLink(ctx context.Context, path string) (bool, error) {
+	deleted := false
+	err := b.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(boltURLsBucket)
+		if buf := bucket.Get([]byte(path)); buf != nil {
+			_, expiresAt, err := decodeBoltValue(buf)
+			if err != nil {
+				return err
+			}
+			deleted = !isExpired(expiresAt, b.now())
+		}
+		if err := bucket.Delete([]byte(path)); err != nil {
+			return err
+		}
+		return tx.Bucket(boltClicksBucket).Delete([]byte(path))
+	})
+	if err != nil {
+		return false, fmt.Errorf("failed to delete URL from bolt: %w", err)
+	}
+	return deleted, nil
+}
+
+// ClaimPathFold claims a folded path in the database, or refreshes its expiry if path already owns it
+func (b *BoltStorage) ClaimPathFold(ctx context.Context, folded, path string, expiresAt time.Time) (string, error) {
+	owner := path
+	err := b.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(boltFoldsBucket)
+		if buf := bucket.Get([]byte(folded)); buf != nil {
+			existing, existingExpiresAt, err := decodeBoltValue(buf)
+			if err != nil {
+				return err
+			}
+			if existing != path && !isExpired(existingExpiresAt, b.now()) {
+				owner = existing
+				return nil
+			}
+		}
+		return bucket.Put([]byte(folded), encodeBoltValue(path, expiresAt))
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to claim folded path in bolt: %w", err)
+	}
+	return owner, nil
+}
+
+// GetPathFold retrieves the path owning a folded path from the database
+func (b *BoltStorage) GetPathFold(ctx context.Context, folded string) (string, bool, error) {
+	path, exists, err := b.get(boltFoldsBucket, folded)
+	if err != nil {
+		return "", false, fmt.Errorf("failed to get folded path from bolt: %w", err)
+	}
+	return path, exists, nil
+}
+
+// ReleasePathFold removes a folded path from the database if path owns it
+func (b *BoltStorage) ReleasePathFold(ctx context.Context, folded, path string) error {
+	err := b.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(boltFoldsBucket)
+		buf := bucket.Get([]byte(folded))
+		if buf == nil {
+			return nil
+		}
+		owner, _, err := decodeBoltValue(buf)
+		if err != nil || owner != path {
+			return err
+		}
+		return bucket.Delete([]byte(folded))
+	})
+	if err != nil {
+		return fmt.Errorf("failed to release folded path in bolt: %w", err)
+	}
+	return nil
+}
+
+// RecordClick counts a click on a link in the database.
+// The stats of a link are kept as one JSON value in the clicks bucket.
+func (b *BoltStorage) RecordClick(ctx context.Context, path string, click Click) error {
+	err := b.db.Update(func(tx *bolt.Tx) error {
+		// Clicks racing with the expiry of their link are not worth keeping
+		linkBuf := tx.Bucket(boltURLsBucket).Get([]byte(path))
+		if linkBuf == nil {
+			return nil
+		}
+		if _, expiresAt, err := decodeBoltValue(linkBuf); err != nil || isExpired(expiresAt, b.now()) {
+			return err
+		}
+
+		bucket := tx.Bucket(boltClicksBucket)
+		stats, err := decodeBoltClickStats(bucket.Get([]byte(path)))
+		if err != nil {
+			return err
+		}
+		stats.add(click)
+
+		buf, err := json.Marshal(stats)
+		if err != nil {
+			return err
+		}
+		return bucket.Put([]byte(path), buf)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to record click in bolt: %w", err)
+	}
+	return nil
+}
+
+// GetClickStats retrieves the click stats of a link from the database
+func (b *BoltStorage) GetClickStats(ctx context.Context, path string) (ClickStats, error) {
+	var stats ClickStats
+	err := b.db.View(func(tx *bolt.Tx) error {
+		var err error
+		stats, err = decodeBoltClickStats(tx.Bucket(boltClicksBucket).Get([]byte(path)))
+		return err
+	})
+	if err != nil {
+		return ClickStats{}, fmt.Errorf("failed to get click stats from bolt: %w", err)
+	}
+	return stats, nil
+}
+
+// decodeBoltClickStats parses stored click stats, a nil buf has no clicks yet
+func decodeBoltClickStats(buf []byte) (ClickStats, error) {
+	stats := newClickStats()
+	if buf == nil {
+		return stats, nil
+	}
+	if err := json.Unmarshal(buf, &stats); err != nil {
+		return ClickStats{}, err
+	}
+	return stats, nil
+}
+
+// sweep periodically removes expired entries until the storage is closed
+func (b *BoltStorage) sweep() {
+	ticker := time.NewTicker(boltSweepInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-b.stop:
+			return
+		case <-ticker.C:
+			if err := b.deleteExpired(); err != nil {
+				log.Printf("Failed to remove expired bolt entries: %v", err)
+			}
+		}
+	}
+}
+
+// deleteExpired removes all expired entries from the database
+func (b *BoltStorage) deleteExpired() error {
+	now := b.now()
+	return b.db.Update(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{boltSpentBucket, boltURLsBucket, boltFoldsBucket} {
+			bucket := tx.Bucket(name)
+			var expired [][]byte
+			err := bucket.ForEach(func(k, v []byte) error {
+				_, expiresAt, err := decodeBoltValue(v)
+				if err != nil {
+					// Nothing can read it anyway, but leave a trace of what was lost
+					log.Printf("Removing bolt entry %s/%s that can't be decoded: %v", name, k, err)
+				}
+				if err != nil || isExpired(expiresAt, now) {
+					expired = append(expired, append([]byte{}, k...))
+				}
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+			for _, k := range expired {
+				if err := bucket.Delete(k); err != nil {
+					return err
+				}
+				// The stats of a link go with it
+				if bytes.Equal(name, boltURLsBucket) {
+					if err := tx.Bucket(boltClicksBucket).Delete(k); err != nil {
+						return err
+					}
+				}
+			}
+		}
+		return nil
+	})
+}
+
+// Close stops the sweeper and closes the database file
+func (b *BoltStorage) Close() error {
+	var err error
+	b.closeOnce.Do(func() {
+		close(b.stop)
+		err = b.db.Close()
+	})
+	return err
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+func TestBoltValueRoundTrip(t *testing.T) {
+	expiresAt := time.Date(2001, 9, 1, 12, 0, 0, 0, time.UTC)
+	for _, want := range []time.Time{expiresAt, {}} {
+		value, got, err := decodeBoltValue(encodeBoltValue("http://example.com", want))
+		if err != nil || value != "http://example.com" || !got.Equal(want) {
+			t.Errorf("decodeBoltValue = %q, %v, %v, expected %v", value, got, err, want)
+		}
+	}
+	if _, _, err := decodeBoltValue([]byte("short")); err == nil {
+		t.Errorf("decodeBoltValue accepted a value without an expiry time")
+	}
+}
+
+func TestBoltStorageExpiry(t *testing.T) {
+	now := time.Date(2001, 9, 1, 12, 0, 0, 0, time.UTC)
+	dbPath := filepath.Join(t.TempDir(), "test.db")
+	storage, err := NewBoltStorage(dbPath)
+	if err != nil {
+		t.Fatalf("failed to open bolt storage: %v", err)
+	}
+	storage.now = func() time.Time { return now }
+	defer storage.Close()
+
+	storeTestLink(t, storage, "short", Link{URL: "http://example.com", ExpiresAt: now.Add(24 * time.Hour)})
+	storeTestLink(t, storage, "forever", Link{URL: "http://example.com"})
+	if _, err := storage.MarkSpent(context.Background(), "challenge", time.Hour); err != nil {
+		t.Fatalf("MarkSpent failed: %v", err)
+	}
+
+	now = now.Add(23 * time.Hour)
+	if _, exists, _ := storage.GetLink(context.Background(), "short"); !exists {
+		t.Errorf("URL mapping expired before 24 hours")
+	}
+	if marked, _ := storage.MarkSpent(context.Background(), "challenge", time.Hour); !marked {
+		t.Errorf("spent challenge did not expire after an hour")
+	}
+
+	now = now.Add(2 * time.Hour)
+	if _, exists, _ := storage.GetLink(context.Background(), "short"); exists {
+		t.Errorf("URL mapping did not expire after 24 hours")
+	}
+
+	// The sweeper removes expired and undecodable entries, and leaves the rest alone
+	err = storage.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(boltURLsBucket).Put([]byte("corrupt"), []byte("short"))
+	})
+	if err != nil {
+		t.Fatalf("failed to store a corrupt entry: %v", err)
+	}
+	now = now.Add(48 * time.Hour)
+	if err := storage.deleteExpired(); err != nil {
+		t.Fatalf("deleteExpired failed: %v", err)
+	}
+	counts := make(map[string]int)
+	storage.db.View(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{boltURLsBucket, boltSpentBucket} {
+			counts[string(name)] = tx.Bucket(name).Stats().KeyN
+		}
+		return nil
+	})
+	if counts["urls"] != 1 || counts["spent"] != 0 {
+		t.Errorf("deleteExpired left %d URLs and %d spent challenges, expected only the permanent URL", counts["urls"], counts["spent"])
+	}
+
+	// Links survive reopening the database file
+	if err := storage.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+	reopened, err := NewBoltStorage(dbPath)
+	if err != nil {
+		t.Fatalf("failed to reopen bolt storage: %v", err)
+	}
+	defer reopened.Close()
+	if link, exists, err := reopened.GetLink(context.Background(), "forever"); err != nil || !exists || link.URL != "http://example.com" {
+		t.Errorf("GetLink after reopening = %+v, %t, %v, expected the permanent link", link, exists, err)
+	}
+}
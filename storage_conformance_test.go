@@ -0,0 +1,525 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testClock is a clock for storage backends that only moves when told to.
+// Backends on a real server keep their own time, they switch it to the wall clock.
+type testClock struct {
+	mu   sync.Mutex
+	now  time.Time
+	wall bool
+}
+
+func newTestClock() *testClock {
+	return &testClock{now: time.Date(2001, 9, 1, 12, 0, 0, 0, time.UTC)}
+}
+
+// Now returns the current time of the clock
+func (c *testClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.wall {
+		return time.Now()
+	}
+	return c.now
+}
+
+// Advance moves the clock forward by d, or skips the rest of the test on the wall clock
+func (c *testClock) Advance(t *testing.T, d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.wall {
+		t.Skip("the clock of a real server can't be moved")
+	}
+	c.now = c.now.Add(d)
+}
+
+// useWallClock makes the clock follow the wall clock
+func (c *testClock) useWallClock() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.wall = true
+}
+
+// storageFactory opens an empty backend that reads the time from clock
+type storageFactory func(t *testing.T, clock *testClock) StorageBackend
+
+// conformanceStorages returns the factory of every backend the conformance suite runs against.
+// Redis runs against an in-process fake, so no server is needed. The fake runs Go stand-ins
+// for the Lua scripts, set TEST_REDIS_URL to also run the suite and the real scripts against
+// a scratch Redis or Valkey server, whose database is flushed by every test.
+func conformanceStorages() map[string]storageFactory {
+	storages := map[string]storageFactory{
+		"local": func(t *testing.T, clock *testClock) StorageBackend {
+			storage := NewLocalMapStorage()
+			storage.now = clock.Now
+			t.Cleanup(func() { storage.Close() })
+			return storage
+		},
+		"bolt": func(t *testing.T, clock *testClock) StorageBackend {
+			storage, err := NewBoltStorage(filepath.Join(t.TempDir(), "test.db"))
+			if err != nil {
+				t.Fatalf("failed to open bolt storage: %v", err)
+			}
+			storage.now = clock.Now
+			t.Cleanup(func() { storage.Close() })
+			return storage
+		},
+		"redis": func(t *testing.T, clock *testClock) StorageBackend {
+			return newFakeRedisStorage(t, clock.Now)
+		},
+	}
+	if rawURL := os.Getenv("TEST_REDIS_URL"); rawURL != "" {
+		storages["redis-server"] = func(t *testing.T, clock *testClock) StorageBackend {
+			clock.useWallClock()
+			return newTestRedisServerStorage(t, rawURL)
+		}
+	}
+	return storages
+}
+
+// newTestRedisServerStorage connects to the server at rawURL and empties its database
+func newTestRedisServerStorage(t *testing.T, rawURL string) *RedisStorage {
+	config, err := parseRedisURL(rawURL)
+	if err != nil {
+		t.Fatalf("invalid TEST_REDIS_URL: %v", err)
+	}
+	storage, err := NewRedisStorage(config)
+	if err != nil {
+		t.Fatalf("failed to connect to TEST_REDIS_URL: %v", err)
+	}
+	t.Cleanup(func() { storage.Close() })
+
+	if err := storage.client.FlushDB(context.Background()).Err(); err != nil {
+		t.Fatalf("failed to flush the test database: %v", err)
+	}
+	return storage
+}
+
+func TestStorageConformance(t *testing.T) {
+	for name, open := range conformanceStorages() {
+		t.Run(name, func(t *testing.T) {
+			testChallengeStoreConformance(t, func(t *testing.T, clock *testClock) ChallengeStore { return open(t, clock) })
+			testLinkStoreConformance(t, func(t *testing.T, clock *testClock) LinkStore { return open(t, clock) })
+			testCloseConformance(t, open)
+		})
+	}
+}
+
+// testChallengeStoreConformance checks the behaviour every ChallengeStore must have
+func testChallengeStoreConformance(t *testing.T, open func(t *testing.T, clock *testClock) ChallengeStore) {
+	t.Run("MarkSpent", func(t *testing.T) {
+		store := open(t, newTestClock())
+		if marked, err := store.MarkSpent(context.Background(), "challenge", time.Hour); err != nil || !marked {
+			t.Fatalf("MarkSpent = %t, %v, expected a fresh challenge to be marked", marked, err)
+		}
+		if marked, err := store.MarkSpent(context.Background(), "challenge", time.Hour); err != nil || marked {
+			t.Errorf("second MarkSpent = %t, %v, expected the challenge to be spent", marked, err)
+		}
+		if marked, err := store.MarkSpent(context.Background(), "other", time.Hour); err != nil || !marked {
+			t.Errorf("MarkSpent(other) = %t, %v, expected a fresh challenge to be marked", marked, err)
+		}
+	})
+
+	t.Run("MarkSpentExpiry", func(t *testing.T) {
+		clock := newTestClock()
+		store := open(t, clock)
+		if _, err := store.MarkSpent(context.Background(), "challenge", time.Hour); err != nil {
+			t.Fatalf("MarkSpent failed: %v", err)
+		}
+		clock.Advance(t, 59*time.Minute)
+		if marked, _ := store.MarkSpent(context.Background(), "challenge", time.Hour); marked {
+			t.Errorf("spent challenge expired before its TTL")
+		}
+		clock.Advance(t, 2*time.Minute)
+		if marked, _ := store.MarkSpent(context.Background(), "challenge", time.Hour); !marked {
+			t.Errorf("spent challenge did not expire after its TTL")
+		}
+	})
+
+	t.Run("MarkSpentConcurrent", func(t *testing.T) {
+		store := open(t, newTestClock())
+		marked := runConcurrently(t, 50, func(int) (bool, error) {
+			return store.MarkSpent(context.Background(), "challenge", time.Hour)
+		})
+		if marked != 1 {
+			t.Errorf("expected the challenge to be spent exactly once, got %d", marked)
+		}
+	})
+}
+
+// testLinkStoreConformance checks the behaviour every LinkStore must have
+func testLinkStoreConformance(t *testing.T, open func(t *testing.T, clock *testClock) LinkStore) {
+	t.Run("Missing", func(t *testing.T) {
+		store := open(t, newTestClock())
+		if link, exists, err := store.GetLink(context.Background(), "missing"); err != nil || exists {
+			t.Errorf("GetLink = %+v, %t, %v, expected nothing", link, exists, err)
+		}
+		if path, exists, err := store.GetPathFold(context.Background(), "missing"); err != nil || exists {
+			t.Errorf("GetPathFold = %s, %t, %v, expected nothing", path, exists, err)
+		}
+		if stats, err := store.GetClickStats(context.Background(), "missing"); err != nil || stats.Total != 0 || stats.Classes == nil || stats.Days == nil {
+			t.Errorf("GetClickStats = %+v, %v, expected empty stats", stats, err)
+		}
+		if updated, err := store.UpdateLink(context.Background(), "missing", Link{URL: "http://example.com"}); err != nil || updated {
+			t.Errorf("UpdateLink = %t, %v, expected nothing to update", updated, err)
+		}
+		if _, exists, _ := store.GetLink(context.Background(), "missing"); exists {
+			t.Errorf("UpdateLink created a missing link")
+		}
+		if deleted, err := store.DeleteLink(context.Background(), "missing"); err != nil || deleted {
+			t.Errorf("DeleteLink = %t, %v, expected nothing to delete", deleted, err)
+		}
+		if err := store.ReleasePathFold(context.Background(), "missing", "path"); err != nil {
+			t.Errorf("ReleasePathFold failed: %v", err)
+		}
+	})
+
+	t.Run("Overwrite", func(t *testing.T) {
+		store := open(t, newTestClock())
+		if stored, err := store.StoreLinkIfAbsent(context.Background(), "path", Link{URL: "http://example.com/1"}); err != nil || !stored {
+			t.Fatalf("StoreLinkIfAbsent = %t, %v, expected the link to be stored", stored, err)
+		}
+		if stored, err := store.StoreLinkIfAbsent(context.Background(), "path", Link{URL: "http://example.com/2"}); err != nil || stored {
+			t.Errorf("StoreLinkIfAbsent on a taken path = %t, %v, expected nothing to be stored", stored, err)
+		}
+		expectLinkURL(t, store, "path", "http://example.com/1")
+
+		if updated, err := store.UpdateLink(context.Background(), "path", Link{URL: "http://example.com/4"}); err != nil || !updated {
+			t.Fatalf("UpdateLink = %t, %v, expected the link to be updated", updated, err)
+		}
+		expectLinkURL(t, store, "path", "http://example.com/4")
+
+		if deleted, err := store.DeleteLink(context.Background(), "path"); err != nil || !deleted {
+			t.Fatalf("DeleteLink = %t, %v, expected the link to be deleted", deleted, err)
+		}
+		if _, exists, _ := store.GetLink(context.Background(), "path"); exists {
+			t.Errorf("link still exists after delete")
+		}
+		if deleted, err := store.DeleteLink(context.Background(), "path"); err != nil || deleted {
+			t.Errorf("second DeleteLink = %t, %v, expected nothing to delete", deleted, err)
+		}
+		if stored, _ := store.StoreLinkIfAbsent(context.Background(), "path", Link{URL: "http://example.com/5"}); !stored {
+			t.Errorf("deleted path could not be claimed again")
+		}
+	})
+
+	t.Run("RoundTrip", func(t *testing.T) {
+		clock := newTestClock()
+		store := open(t, clock)
+		want := Link{
+			URL:         "http://example.com",
+			CreatedAt:   clock.Now().Round(0),
+			ExpiresAt:   clock.Now().Add(time.Hour).Round(0),
+			OwnerHash:   "owner",
+			CreatorHash: "creator",
+			Client:      ClientWAP,
+			Difficulty:  3,
+			Disabled:    true,
+		}
+		if stored, err := store.StoreLinkIfAbsent(context.Background(), "link", want); err != nil || !stored {
+			t.Fatalf("StoreLinkIfAbsent = %t, %v, expected the link to be stored", stored, err)
+		}
+		got, exists, err := store.GetLink(context.Background(), "link")
+		if err != nil || !exists {
+			t.Fatalf("GetLink = %+v, %t, %v, expected the stored link", got, exists, err)
+		}
+		if !got.CreatedAt.Equal(want.CreatedAt) || !got.ExpiresAt.Equal(want.ExpiresAt) {
+			t.Errorf("GetLink = %+v, expected %+v", got, want)
+		}
+		got.CreatedAt, got.ExpiresAt = want.CreatedAt, want.ExpiresAt
+		if got != want {
+			t.Errorf("GetLink = %+v, expected %+v", got, want)
+		}
+	})
+
+	t.Run("LinkExpiry", func(t *testing.T) {
+		clock := newTestClock()
+		store := open(t, clock)
+		storeTestLink(t, store, "short", Link{URL: "http://example.com", ExpiresAt: clock.Now().Add(time.Hour)})
+		storeTestLink(t, store, "updated", Link{URL: "http://example.com", ExpiresAt: clock.Now().Add(time.Hour)})
+		storeTestLink(t, store, "forever", Link{URL: "http://example.com"})
+
+		clock.Advance(t, 59*time.Minute)
+		if _, exists, _ := store.GetLink(context.Background(), "short"); !exists {
+			t.Errorf("link expired early")
+		}
+		if updated, _ := store.UpdateLink(context.Background(), "updated", Link{URL: "http://example.org"}); !updated {
+			t.Errorf("link could not be made permanent")
+		}
+
+		clock.Advance(t, 2*time.Minute)
+		if _, exists, _ := store.GetLink(context.Background(), "short"); exists {
+			t.Errorf("link did not expire")
+		}
+		if updated, _ := store.UpdateLink(context.Background(), "short", Link{URL: "http://example.org"}); updated {
+			t.Errorf("UpdateLink revived an expired link")
+		}
+		if deleted, _ := store.DeleteLink(context.Background(), "short"); deleted {
+			t.Errorf("DeleteLink deleted an expired link")
+		}
+		if stored, _ := store.StoreLinkIfAbsent(context.Background(), "short", Link{URL: "http://example.org"}); !stored {
+			t.Errorf("expired path could not be claimed again")
+		}
+
+		clock.Advance(t, 1000*time.Hour)
+		for _, path := range []string{"updated", "forever", "short"} {
+			if _, exists, _ := store.GetLink(context.Background(), path); !exists {
+				t.Errorf("permanent link %s expired", path)
+			}
+		}
+	})
+
+	t.Run("PathFolds", func(t *testing.T) {
+		clock := newTestClock()
+		store := open(t, clock)
+		expiresAt := clock.Now().Add(time.Hour)
+		if owner, err := store.ClaimPathFold(context.Background(), "lol", "L0L", expiresAt); err != nil || owner != "L0L" {
+			t.Fatalf("ClaimPathFold = %s, %v, expected L0L to claim it", owner, err)
+		}
+		if owner, _ := store.ClaimPathFold(context.Background(), "lol", "lol", expiresAt); owner != "L0L" {
+			t.Errorf("ClaimPathFold by another path = %s, expected L0L to keep it", owner)
+		}
+		if err := store.ReleasePathFold(context.Background(), "lol", "lol"); err != nil {
+			t.Fatalf("ReleasePathFold failed: %v", err)
+		}
+		if path, exists, _ := store.GetPathFold(context.Background(), "lol"); !exists || path != "L0L" {
+			t.Errorf("folded path was released by another path")
+		}
+
+		// The owner can refresh its claim and release it
+		if owner, err := store.ClaimPathFold(context.Background(), "abc", "ABC", expiresAt); err != nil || owner != "ABC" {
+			t.Fatalf("ClaimPathFold = %s, %v, expected ABC to claim it", owner, err)
+		}
+		if owner, err := store.ClaimPathFold(context.Background(), "abc", "ABC", time.Time{}); err != nil || owner != "ABC" {
+			t.Errorf("refreshing ClaimPathFold = %s, %v, expected ABC to keep it", owner, err)
+		}
+		if err := store.ReleasePathFold(context.Background(), "abc", "ABC"); err != nil {
+			t.Fatalf("ReleasePathFold failed: %v", err)
+		}
+		if _, exists, _ := store.GetPathFold(context.Background(), "abc"); exists {
+			t.Errorf("folded path still exists after release")
+		}
+
+		clock.Advance(t, 61*time.Minute)
+		if _, exists, _ := store.GetPathFold(context.Background(), "lol"); exists {
+			t.Errorf("folded path did not expire")
+		}
+		if owner, _ := store.ClaimPathFold(context.Background(), "lol", "lol", time.Time{}); owner != "lol" {
+			t.Errorf("ClaimPathFold after expiry = %s, expected lol to claim it", owner)
+		}
+		clock.Advance(t, 1000*time.Hour)
+		if path, exists, _ := store.GetPathFold(context.Background(), "lol"); !exists || path != "lol" {
+			t.Errorf("permanent folded path expired")
+		}
+	})
+
+	t.Run("Clicks", func(t *testing.T) {
+		clock := newTestClock()
+		store := open(t, clock)
+		click := Click{Class: ClientWAP, At: clock.Now()}
+
+		if err := store.RecordClick(context.Background(), "missing", click); err != nil {
+			t.Fatalf("RecordClick failed: %v", err)
+		}
+		if stats, _ := store.GetClickStats(context.Background(), "missing"); stats.Total != 0 {
+			t.Errorf("clicks on a missing link were counted")
+		}
+
+		storeTestLink(t, store, "path", Link{URL: "http://example.com"})
+		for i := 0; i < 2; i++ {
+			if err := store.RecordClick(context.Background(), "path", click); err != nil {
+				t.Fatalf("RecordClick failed: %v", err)
+			}
+		}
+		if _, err := store.UpdateLink(context.Background(), "path", Link{URL: "http://example.org"}); err != nil {
+			t.Fatalf("UpdateLink failed: %v", err)
+		}
+		stats, err := store.GetClickStats(context.Background(), "path")
+		if err != nil || stats.Total != 2 || stats.Classes[ClientWAP] != 2 || stats.Days[click.At.UTC().Format(clickDayFormat)] != 2 {
+			t.Errorf("GetClickStats after UpdateLink = %+v, %v, expected 2 WAP clicks", stats, err)
+		}
+
+		// Clicks are counted by client class and by day
+		nextDay := Click{Class: ClientBot, At: click.At.AddDate(0, 0, 1)}
+		if err := store.RecordClick(context.Background(), "path", nextDay); err != nil {
+			t.Fatalf("RecordClick failed: %v", err)
+		}
+		stats, err = store.GetClickStats(context.Background(), "path")
+		if err != nil || stats.Total != 3 || stats.Classes[ClientWAP] != 2 || stats.Classes[ClientBot] != 1 || stats.Classes[ClientHTML] != 0 {
+			t.Errorf("GetClickStats = %+v, %v, expected 2 WAP clicks and 1 bot", stats, err)
+		}
+		if stats.Days[click.At.UTC().Format(clickDayFormat)] != 2 || stats.Days[nextDay.At.UTC().Format(clickDayFormat)] != 1 {
+			t.Errorf("GetClickStats days = %v, expected 2 clicks and 1 the next day", stats.Days)
+		}
+
+		if err := store.RecordClick(context.Background(), "path", click); err != nil {
+			t.Fatalf("RecordClick failed: %v", err)
+		}
+		if _, err := store.DeleteLink(context.Background(), "path"); err != nil {
+			t.Fatalf("DeleteLink failed: %v", err)
+		}
+		if stats, _ := store.GetClickStats(context.Background(), "path"); stats.Total != 0 {
+			t.Errorf("DeleteLink kept %d clicks", stats.Total)
+		}
+
+		// Clicks on an expired link are ignored and a new link on its path starts from zero
+		storeTestLink(t, store, "path", Link{URL: "http://example.com", ExpiresAt: clock.Now().Add(time.Hour)})
+		if err := store.RecordClick(context.Background(), "path", Click{Class: ClientBot, At: clock.Now(), ExpiresAt: clock.Now().Add(time.Hour)}); err != nil {
+			t.Fatalf("RecordClick failed: %v", err)
+		}
+		clock.Advance(t, 61*time.Minute)
+		if err := store.RecordClick(context.Background(), "path", click); err != nil {
+			t.Fatalf("RecordClick failed: %v", err)
+		}
+		if stored, _ := store.StoreLinkIfAbsent(context.Background(), "path", Link{URL: "http://example.org"}); !stored {
+			t.Fatalf("expired path could not be claimed again")
+		}
+		if stats, _ := store.GetClickStats(context.Background(), "path"); stats.Total != 0 {
+			t.Errorf("new link on an expired path starts with %d clicks", stats.Total)
+		}
+	})
+
+	t.Run("Concurrent", func(t *testing.T) {
+		store := open(t, newTestClock())
+
+		var mu sync.Mutex
+		var winner string
+		stored := runConcurrently(t, 50, func(i int) (bool, error) {
+			fullURL := fmt.Sprintf("http://example.com/%d", i)
+			stored, err := store.StoreLinkIfAbsent(context.Background(), "race", Link{URL: fullURL})
+			if stored {
+				mu.Lock()
+				winner = fullURL
+				mu.Unlock()
+			}
+			return stored, err
+		})
+		if stored != 1 {
+			t.Errorf("expected exactly one request to store the path, got %d", stored)
+		}
+		expectLinkURL(t, store, "race", winner)
+
+		claimed := runConcurrently(t, 50, func(i int) (bool, error) {
+			path := fmt.Sprintf("path%d", i)
+			owner, err := store.ClaimPathFold(context.Background(), "race", path, time.Time{})
+			return owner == path, err
+		})
+		if claimed != 1 {
+			t.Errorf("expected exactly one path to claim the folded path, got %d", claimed)
+		}
+
+		runConcurrently(t, 50, func(int) (bool, error) {
+			return true, store.RecordClick(context.Background(), "race", Click{Class: ClientHTML, At: time.Now()})
+		})
+		if stats, _ := store.GetClickStats(context.Background(), "race"); stats.Total != 50 {
+			t.Errorf("expected 50 concurrent clicks to be counted, got %d", stats.Total)
+		}
+	})
+}
+
+// testCloseConformance checks that closing a backend works and can be repeated
+func testCloseConformance(t *testing.T, open storageFactory) {
+	t.Run("Close", func(t *testing.T) {
+		storage := open(t, newTestClock())
+		if err := storage.Close(); err != nil {
+			t.Errorf("Close failed: %v", err)
+		}
+		if err := storage.Close(); err != nil {
+			t.Errorf("second Close failed: %v", err)
+		}
+	})
+}
+
+// storeTestLink stores a link at a free path, failing the test if it can't
+func storeTestLink(t *testing.T, store LinkStore, path string, link Link) {
+	t.Helper()
+	if stored, err := store.StoreLinkIfAbsent(context.Background(), path, link); err != nil || !stored {
+		t.Fatalf("StoreLinkIfAbsent(%s) = %t, %v, expected the link to be stored", path, stored, err)
+	}
+}
+
+// expectLinkURL fails the test unless the link at path goes to want
+func expectLinkURL(t *testing.T, store LinkStore, path, want string) {
+	t.Helper()
+	link, exists, err := store.GetLink(context.Background(), path)
+	if err != nil || !exists || link.URL != want {
+		t.Errorf("GetLink(%s) = %+v, %t, %v, expected %s", path, link, exists, err, want)
+	}
+}
+
+// runConcurrently runs fn on n goroutines at once and returns how many returned true
+func runConcurrently(t *testing.T, n int, fn func(i int) (bool, error)) int {
+	t.Helper()
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	count := 0
+	start := make(chan struct{})
+
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			<-start
+			ok, err := fn(i)
+			if err != nil {
+				t.Errorf("concurrent call failed: %v", err)
+				return
+			}
+			if ok {
+				mu.Lock()
+				count++
+				mu.Unlock()
+			}
+		}(i)
+	}
+	close(start)
+	wg.Wait()
+
+	return count
+}
+
+// TestRedisServerScriptExpiry checks the expiry the Lua scripts give their keys on a real
+// server, whose clock the conformance suite can't move
+func TestRedisServerScriptExpiry(t *testing.T) {
+	rawURL := os.Getenv("TEST_REDIS_URL")
+	if rawURL == "" {
+		t.Skip("TEST_REDIS_URL is not set")
+	}
+	storage := newTestRedisServerStorage(t, rawURL)
+	ctx := context.Background()
+	expiresAt := time.Now().Add(time.Hour)
+
+	storeTestLink(t, storage, "path", Link{URL: "http://example.com", ExpiresAt: expiresAt})
+	if err := storage.RecordClick(ctx, "path", Click{Class: ClientWAP, At: time.Now(), ExpiresAt: expiresAt}); err != nil {
+		t.Fatalf("RecordClick failed: %v", err)
+	}
+	if owner, err := storage.ClaimPathFold(ctx, "path", "PATH", expiresAt); err != nil || owner != "PATH" {
+		t.Fatalf("ClaimPathFold = %s, %v, expected PATH to claim it", owner, err)
+	}
+	if _, err := storage.ClaimPathFold(ctx, "forever", "forever", time.Time{}); err != nil {
+		t.Fatalf("ClaimPathFold failed: %v", err)
+	}
+
+	for key, expected := range map[string]time.Duration{
+		redisClicksKey("path"): time.Hour,
+		"fold:path":            time.Hour,
+		"fold:forever":         -1, // no expiry
+	} {
+		ttl, err := storage.client.PTTL(ctx, key).Result()
+		if err != nil {
+			t.Fatalf("PTTL %s failed: %v", key, err)
+		}
+		if expected < 0 && ttl != -1 || expected > 0 && (ttl <= expected-time.Minute || ttl > expected) {
+			t.Errorf("%s expires in %v, expected %v", key, ttl, expected)
+		}
+	}
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLocalMapStorageExpiry(t *testing.T) {
+	now := time.Date(2001, 9, 1, 12, 0, 0, 0, time.UTC)
+	storage := NewLocalMapStorage()
+	storage.now = func() time.Time { return now }
+	defer storage.Close()
+
+	storeTestLink(t, storage, "short", Link{URL: "http://example.com", ExpiresAt: now.Add(24 * time.Hour)})
+	storeTestLink(t, storage, "forever", Link{URL: "http://example.com"})
+	if _, err := storage.MarkSpent(context.Background(), "challenge", time.Hour); err != nil {
+		t.Fatalf("MarkSpent failed: %v", err)
+	}
+
+	now = now.Add(23 * time.Hour)
+	if _, exists, _ := storage.GetLink(context.Background(), "short"); !exists {
+		t.Errorf("URL mapping expired before 24 hours")
+	}
+	if marked, _ := storage.MarkSpent(context.Background(), "challenge", time.Hour); !marked {
+		t.Errorf("spent challenge did not expire after an hour")
+	}
+
+	now = now.Add(2 * time.Hour)
+	if _, exists, _ := storage.GetLink(context.Background(), "short"); exists {
+		t.Errorf("URL mapping did not expire after 24 hours")
+	}
+	if stored, _ := storage.StoreLinkIfAbsent(context.Background(), "short", Link{URL: "http://example.org", ExpiresAt: now.Add(time.Hour)}); !stored {
+		t.Errorf("expired path could not be claimed again")
+	}
+
+	now = now.Add(48 * time.Hour)
+	storage.deleteExpired()
+	if len(storage.links) != 1 || len(storage.spent) != 0 {
+		t.Errorf("deleteExpired left %d URLs and %d spent challenges, expected only the permanent URL", len(storage.links), len(storage.spent))
+	}
+	if _, exists, _ := storage.GetLink(context.Background(), "forever"); !exists {
+		t.Errorf("permanent URL mapping expired")
+	}
+
+	if err := storage.Close(); err != nil {
+		t.Errorf("Close failed: %v", err)
+	}
+}
+
+func TestDecodeLegacyLink(t *testing.T) {
+	link, err := decodeLink("http://example.com/{x}")
+	if err != nil || link.URL != "http://example.com/{x}" || !link.CreatedAt.IsZero() {
+		t.Errorf("decodeLink(plain URL) = %+v, %v, expected just the URL", link, err)
+	}
+
+	link, err = decodeLink(`{"url":"http://example.com","created_at":"2001-09-01T12:00:00Z","expires_at":"0001-01-01T00:00:00Z","owner_hash":"owner"}`)
+	if err != nil || link.URL != "http://example.com" || link.OwnerHash != "owner" || link.CreatedAt.Year() != 2001 {
+		t.Errorf("decodeLink(unversioned JSON) = %+v, %v", link, err)
+	}
+
+	if _, err := decodeLink(`{"v":99,"url":"http://example.com"}`); err == nil {
+		t.Errorf("decodeLink accepted a link from a newer version")
+	}
+
+	value, _ := encodeLink(Link{URL: "http://example.com"})
+	if !strings.HasPrefix(value, `{"v":1,`) {
+		t.Errorf("encodeLink = %s, expected a version 1 link", value)
+	}
+}
+
+func TestRedisLegacyLinkExpiry(t *testing.T) {
+	storages := map[string]*RedisStorage{"fake": newFakeRedisStorage(t, time.Now)}
+	if rawURL := os.Getenv("TEST_REDIS_URL"); rawURL != "" {
+		storages["server"] = newTestRedisServerStorage(t, rawURL)
+	}
+
+	for name, storage := range storages {
+		// Links from before the JSON format were stored as plain URLs with a 24 hour TTL
+		ctx := context.Background()
+		if err := storage.client.Set(ctx, redisLinkKey("legacy"), "http://example.com", 24*time.Hour).Err(); err != nil {
+			t.Fatalf("%s: SET failed: %v", name, err)
+		}
+		if err := storage.client.Set(ctx, redisLinkKey("kept"), "http://example.com", 0).Err(); err != nil {
+			t.Fatalf("%s: SET failed: %v", name, err)
+		}
+
+		link, exists, err := storage.GetLink(ctx, "legacy")
+		expected := time.Now().Add(24 * time.Hour)
+		if err != nil || !exists || link.URL != "http://example.com" || link.ExpiresAt.Before(expected.Add(-time.Minute)) || link.ExpiresAt.After(expected) {
+			t.Errorf("%s: GetLink(legacy) = %+v, %t, %v, expected it to expire at %v", name, link, exists, err, expected)
+		}
+		if link, exists, err := storage.GetLink(ctx, "kept"); err != nil || !exists || !link.ExpiresAt.IsZero() {
+			t.Errorf("%s: GetLink(kept) = %+v, %t, %v, expected it to never expire", name, link, exists, err)
+		}
+	}
+}
+
+func TestNewStorage(t *testing.T) {
+	t.Setenv("USE_REDIS", "")
+	t.Setenv("ENV", "")
+	t.Setenv("REDIS_URL", "")
+	t.Setenv("REDIS_ADDR", "127.0.0.1:1") // nothing listens here
+	t.Setenv("BOLT_PATH", filepath.Join(t.TempDir(), "test.db"))
+
+	tests := []struct {
+		challenges string
+		links      string
+		useRedis   bool
+		shared     bool
+	}{
+		{"memory", "bolt", false, false},
+		{"", "", false, true},
+		{"memory", "MEMORY", false, true},
+		{"", "bolt", true, true}, // Redis is unreachable and the default falls back to bolt
+	}
+	for _, tt := range tests {
+		t.Setenv("CHALLENGE_STORAGE", tt.challenges)
+		t.Setenv("LINK_STORAGE", tt.links)
+		t.Setenv("USE_REDIS", strconv.FormatBool(tt.useRedis))
+
+		storage, err := NewStorage()
+		if err != nil {
+			t.Fatalf("NewStorage(%q, %q) failed: %v", tt.challenges, tt.links, err)
+		}
+		if shared := storage.Challenges.(StorageBackend) == storage.Links.(StorageBackend); shared != tt.shared {
+			t.Errorf("NewStorage(%q, %q) shared = %t, expected %t", tt.challenges, tt.links, shared, tt.shared)
+		}
+		if tt.links == "bolt" {
+			if _, ok := storage.Links.(*BoltStorage); !ok {
+				t.Errorf("NewStorage(%q, %q) stores links in %T", tt.challenges, tt.links, storage.Links)
+			}
+		}
+		if err := storage.Close(); err != nil {
+			t.Errorf("Close failed: %v", err)
+		}
+	}
+
+	// Explicitly configured backends that can't be opened don't fall back
+	failing := []struct {
+		challenges string
+		links      string
+		useRedis   bool
+		boltPath   string
+	}{
+		{"", "floppy", false, "test.db"},
+		{"redis", "memory", false, "test.db"},
+		{"", "redis", true, "test.db"}, // even when the default already fell back
+		{"memory", "bolt", false, ""},
+	}
+	for _, tt := range failing {
+		t.Setenv("CHALLENGE_STORAGE", tt.challenges)
+		t.Setenv("LINK_STORAGE", tt.links)
+		t.Setenv("USE_REDIS", strconv.FormatBool(tt.useRedis))
+		if tt.boltPath != "" {
+			tt.boltPath = filepath.Join(t.TempDir(), tt.boltPath)
+		}
+		t.Setenv("BOLT_PATH", tt.boltPath)
+
+		if storage, err := NewStorage(); err == nil {
+			storage.Close()
+			t.Errorf("NewStorage(%q, %q) succeeded, expected an error", tt.challenges, tt.links)
+		}
+	}
+}
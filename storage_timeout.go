@@ -0,0 +1,58 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"html/template"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// defaultStorageTimeout is how long a request waits for storage when STORAGE_TIMEOUT is not set
+const defaultStorageTimeout = 2 * time.Second
+
+// storageTimeout bounds the storage calls of every request
+var storageTimeout = defaultStorageTimeout
+
+// storageRetryAfter is the Retry-After hint, in seconds, sent with the degraded page
+const storageRetryAfter = "30"
+
+// storageContext returns the context for the storage calls of a request.
+// It is cancelled when the client goes away or after storageTimeout.
+func storageContext(c echo.Context) (context.Context, context.CancelFunc) {
+	return context.WithTimeout(c.Request().Context(), storageTimeout)
+}
+
+// isStorageTimeout reports whether err comes from storage not answering in time,
+// or from a request that was given up on
+func isStorageTimeout(err error) bool {
+	var netErr net.Error
+	return errors.Is(err, context.DeadlineExceeded) || errors.Is(err, context.Canceled) ||
+		(errors.As(err, &netErr) && netErr.Timeout())
+}
+
+// serveDegraded serves the page for when storage is too slow to answer
+func serveDegraded(c echo.Context) error {
+	if acceptsWML(c) {
+		// Like the 404 deck, use a 200 status so handsets show the deck instead of their own error
+		return renderWML(c, http.StatusOK, "degraded.wml", nil)
+	}
+
+	tmpl := template.Must(template.ParseFiles("./templates/degraded.html"))
+	c.Response().Header().Set("Content-Type", "text/html")
+	c.Response().Header().Set("Retry-After", storageRetryAfter)
+	c.Response().WriteHeader(http.StatusServiceUnavailable)
+	return tmpl.Execute(c.Response().Writer, nil)
+}
+
+// serveStorageError answers a request whose storage call failed: the degraded page
+// if storage timed out, a plain internal server error otherwise
+func serveStorageError(c echo.Context, err error) error {
+	if isStorageTimeout(err) {
+		return serveDegraded(c)
+	}
+	return c.String(http.StatusInternalServerError, "Internal Server Error")
+}
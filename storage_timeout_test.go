@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// stalledLinkStore is a LinkStore whose lookups never answer before their deadline
+type stalledLinkStore struct {
+	LinkStore
+}
+
+func (s stalledLinkStore) GetLink(ctx context.Context, path string) (Link, bool, error) {
+	<-ctx.Done()
+	return Link{}, false, fmt.Errorf("failed to get URL: %w", ctx.Err())
+}
+
+func TestStorageTimeout(t *testing.T) {
+	e := newTestServer(t)
+	oldStore, oldTimeout := linkStore, storageTimeout
+	linkStore, storageTimeout = stalledLinkStore{linkStore}, 10*time.Millisecond
+	t.Cleanup(func() { linkStore, storageTimeout = oldStore, oldTimeout })
+
+	tests := []struct {
+		target   string
+		accept   string
+		status   int
+		contains string
+	}{
+		{"/slow", "text/html", http.StatusServiceUnavailable, "try again in a minute"},
+		{"/slow", "text/vnd.wap.wml", http.StatusOK, "try again in a minute"},
+		{"/stats/slow", "text/html", http.StatusServiceUnavailable, "try again in a minute"},
+		{"/api/v1/links/slow", "application/json", http.StatusServiceUnavailable, "storage_unavailable"},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodGet, tt.target, nil)
+		req.Header.Set("Accept", tt.accept)
+		rec := httptest.NewRecorder()
+
+		start := time.Now()
+		e.ServeHTTP(rec, req)
+		if elapsed := time.Since(start); elapsed > time.Second {
+			t.Errorf("GET %s took %s, expected it to give up after the storage timeout", tt.target, elapsed)
+		}
+		if rec.Code != tt.status || !strings.Contains(rec.Body.String(), tt.contains) {
+			t.Errorf("GET %s (%s) = %d %q, expected %d containing %q", tt.target, tt.accept, rec.Code, rec.Body.String(), tt.status, tt.contains)
+		}
+		if tt.status == http.StatusServiceUnavailable && rec.Header().Get("Retry-After") == "" {
+			t.Errorf("GET %s has no Retry-After header", tt.target)
+		}
+	}
+}
+
+func TestRedisStorageContext(t *testing.T) {
+	storage := newFakeRedisStorage(t, time.Now)
+
+	ctx, cancel := context.WithTimeout(context.Background(), -time.Second)
+	defer cancel()
+	if _, _, err := storage.GetLink(ctx, "path"); !isStorageTimeout(err) {
+		t.Errorf("GetLink with an expired context = %v, expected a timeout", err)
+	}
+	if _, _, err := storage.GetLink(context.Background(), "path"); err != nil {
+		t.Errorf("GetLink failed: %v", err)
+	}
+}
+
+func TestIsStorageTimeout(t *testing.T) {
+	if !isStorageTimeout(fmt.Errorf("wrapped: %w", context.DeadlineExceeded)) {
+		t.Errorf("a wrapped deadline is a timeout")
+	}
+	if isStorageTimeout(errors.New("connection refused")) || isStorageTimeout(nil) {
+		t.Errorf("other errors are not timeouts")
+	}
+}
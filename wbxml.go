@@ -0,0 +1,548 @@
+package main
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// wmlcContentType is the MIME type of binary (WBXML encoded) WML decks
+const wmlcContentType = "application/vnd.wap.wmlc"
+
+// WBXML global tokens, see WAP-192 WBXML section 7.1
+const (
+	wbxmlSwitchPage = 0x00
+	wbxmlEnd        = 0x01
+	wbxmlEntity     = 0x02
+	wbxmlStrI       = 0x03
+	wbxmlLiteral    = 0x04
+	wbxmlExtI0      = 0x40
+	wbxmlExtI1      = 0x41
+	wbxmlExtI2      = 0x42
+	wbxmlPI         = 0x43
+	wbxmlLiteralC   = 0x44
+	wbxmlExtT0      = 0x80
+	wbxmlExtT1      = 0x81
+	wbxmlExtT2      = 0x82
+	wbxmlStrT       = 0x83
+	wbxmlLiteralA   = 0x84
+	wbxmlExt0       = 0xC0
+	wbxmlExt1       = 0xC1
+	wbxmlExt2       = 0xC2
+	wbxmlOpaque     = 0xC3
+	wbxmlLiteralAC  = 0xC4
+)
+
+// Tag token flags
+const (
+	wbxmlHasAttributes = 0x80
+	wbxmlHasContent    = 0x40
+)
+
+// WBXML document header values for WML 1.1 decks
+const (
+	wbxmlVersion11 = 0x01
+	wbxmlPublicID  = 0x04 // -//WAPFORUM//DTD WML 1.1//EN
+	wbxmlUTF8      = 0x6A // IANA MIBenum for UTF-8
+)
+
+// wmlcMinSharedLength is the minimum length of a string before it is worth moving to the string table
+const wmlcMinSharedLength = 4
+
+// wmlTagTokens is the WML 1.1 tag code page 0
+var wmlTagTokens = map[string]byte{
+	"a":         0x1C,
+	"td":        0x1D,
+	"tr":        0x1E,
+	"table":     0x1F,
+	"p":         0x20,
+	"postfield": 0x21,
+	"anchor":    0x22,
+	"access":    0x23,
+	"b":         0x24,
+	"big":       0x25,
+	"br":        0x26,
+	"card":      0x27,
+	"do":        0x28,
+	"em":        0x29,
+	"fieldset":  0x2A,
+	"go":        0x2B,
+	"head":      0x2C,
+	"i":         0x2D,
+	"img":       0x2E,
+	"input":     0x2F,
+	"meta":      0x30,
+	"noop":      0x31,
+	"prev":      0x32,
+	"onevent":   0x33,
+	"optgroup":  0x34,
+	"option":    0x35,
+	"refresh":   0x36,
+	"select":    0x37,
+	"small":     0x38,
+	"strong":    0x39,
+	"template":  0x3B,
+	"timer":     0x3C,
+	"u":         0x3D,
+	"setvar":    0x3E,
+	"wml":       0x3F,
+}
+
+// wmlAttrStart is an attribute start token: the attribute name plus an optional value prefix
+type wmlAttrStart struct {
+	Name   string
+	Prefix string
+	Token  byte
+}
+
+// wmlAttrStartTokens is the WML 1.1 attribute start code page 0
+var wmlAttrStartTokens = []wmlAttrStart{
+	{"accept-charset", "", 0x05},
+	{"align", "bottom", 0x06},
+	{"align", "center", 0x07},
+	{"align", "left", 0x08},
+	{"align", "middle", 0x09},
+	{"align", "right", 0x0A},
+	{"align", "top", 0x0B},
+	{"alt", "", 0x0C},
+	{"content", "", 0x0D},
+	{"domain", "", 0x0F},
+	{"emptyok", "false", 0x10},
+	{"emptyok", "true", 0x11},
+	{"format", "", 0x12},
+	{"height", "", 0x13},
+	{"hspace", "", 0x14},
+	{"ivalue", "", 0x15},
+	{"iname", "", 0x16},
+	{"label", "", 0x18},
+	{"localsrc", "", 0x19},
+	{"maxlength", "", 0x1A},
+	{"method", "get", 0x1B},
+	{"method", "post", 0x1C},
+	{"mode", "nowrap", 0x1D},
+	{"mode", "wrap", 0x1E},
+	{"multiple", "false", 0x1F},
+	{"multiple", "true", 0x20},
+	{"name", "", 0x21},
+	{"newcontext", "false", 0x22},
+	{"newcontext", "true", 0x23},
+	{"onpick", "", 0x24},
+	{"onenterbackward", "", 0x25},
+	{"onenterforward", "", 0x26},
+	{"ontimer", "", 0x27},
+	{"optional", "false", 0x28},
+	{"optional", "true", 0x29},
+	{"path", "", 0x2A},
+	{"scheme", "", 0x2E},
+	{"sendreferer", "false", 0x2F},
+	{"sendreferer", "true", 0x30},
+	{"size", "", 0x31},
+	{"src", "", 0x32},
+	{"ordered", "true", 0x33},
+	{"ordered", "false", 0x34},
+	{"tabindex", "", 0x35},
+	{"title", "", 0x36},
+	{"type", "", 0x37},
+	{"type", "accept", 0x38},
+	{"type", "delete", 0x39},
+	{"type", "help", 0x3A},
+	{"type", "password", 0x3B},
+	{"type", "onpick", 0x3C},
+	{"type", "onenterbackward", 0x3D},
+	{"type", "onenterforward", 0x3E},
+	{"type", "ontimer", 0x3F},
+	{"type", "options", 0x45},
+	{"type", "prev", 0x46},
+	{"type", "reset", 0x47},
+	{"type", "text", 0x48},
+	{"type", "vnd.", 0x49},
+	{"href", "", 0x4A},
+	{"href", "http://", 0x4B},
+	{"href", "https://", 0x4C},
+	{"value", "", 0x4D},
+	{"vspace", "", 0x4E},
+	{"width", "", 0x4F},
+	{"xml:lang", "", 0x50},
+	{"align", "", 0x52},
+	{"columns", "", 0x53},
+	{"class", "", 0x54},
+	{"id", "", 0x55},
+	{"forua", "false", 0x56},
+	{"forua", "true", 0x57},
+	{"src", "http://", 0x58},
+	{"src", "https://", 0x59},
+	{"http-equiv", "", 0x5A},
+	{"http-equiv", "Content-Type", 0x5B},
+	{"content", "application/vnd.wap.wmlc;charset=", 0x5C},
+	{"http-equiv", "Expires", 0x5D},
+}
+
+// WML variable conversions, encoded as EXT_I_0, EXT_I_1 and EXT_I_2 respectively
+const (
+	wmlConvEscape   = 0
+	wmlConvUnescape = 1
+	wmlConvNone     = 2
+)
+
+// wmlPart is a piece of text or attribute value: either literal text or a variable reference
+type wmlPart struct {
+	Text string
+	Var  string
+	Conv int
+}
+
+// wmlAttr is a single attribute of a WML element
+type wmlAttr struct {
+	Name  string
+	Value []wmlPart
+}
+
+// wmlNode is an element or, when Name is empty, a text node of a parsed WML deck
+type wmlNode struct {
+	Name     string
+	Attrs    []wmlAttr
+	Children []*wmlNode
+	Text     []wmlPart
+}
+
+// parseWMLDeck parses a textual WML deck into its root element.
+// Whitespace is collapsed the same way a WAP gateway compiler would.
+func parseWMLDeck(deck []byte) (*wmlNode, error) {
+	decoder := xml.NewDecoder(bytes.NewReader(deck))
+	decoder.Entity = map[string]string{"nbsp": "\u00a0", "shy": "\u00ad"}
+
+	var root *wmlNode
+	var stack []*wmlNode
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse WML: %w", err)
+		}
+
+		switch t := token.(type) {
+		case xml.StartElement:
+			node := &wmlNode{Name: qualifiedName(t.Name)}
+			for _, attr := range t.Attr {
+				value, err := parseWMLVars(attr.Value)
+				if err != nil {
+					return nil, err
+				}
+				node.Attrs = append(node.Attrs, wmlAttr{Name: qualifiedName(attr.Name), Value: value})
+			}
+			if len(stack) == 0 {
+				if root != nil {
+					return nil, fmt.Errorf("failed to parse WML: multiple root elements")
+				}
+				root = node
+			} else {
+				parent := stack[len(stack)-1]
+				parent.Children = append(parent.Children, node)
+			}
+			stack = append(stack, node)
+		case xml.EndElement:
+			stack = stack[:len(stack)-1]
+		case xml.CharData:
+			text := strings.Join(strings.FieldsFunc(string(t), isXMLSpace), " ")
+			if text == "" || len(stack) == 0 {
+				continue
+			}
+			// Keep a single space where the original text started or ended with whitespace
+			if isXMLSpace(rune(t[0])) {
+				text = " " + text
+			}
+			if isXMLSpace(rune(t[len(t)-1])) {
+				text += " "
+			}
+			parts, err := parseWMLVars(text)
+			if err != nil {
+				return nil, err
+			}
+			parent := stack[len(stack)-1]
+			parent.Children = append(parent.Children, &wmlNode{Text: parts})
+		}
+	}
+
+	if root == nil {
+		return nil, fmt.Errorf("failed to parse WML: no root element")
+	}
+	return root, nil
+}
+
+// isXMLSpace reports whether r is XML whitespace. Unlike unicode.IsSpace it leaves &nbsp; alone.
+func isXMLSpace(r rune) bool {
+	return r == ' ' || r == '\t' || r == '\r' || r == '\n'
+}
+
+// qualifiedName returns the name as written in the document, e.g. xml:lang
+func qualifiedName(name xml.Name) string {
+	if name.Space == "xml" || name.Space == "http://www.w3.org/XML/1998/namespace" {
+		return "xml:" + name.Local
+	}
+	return name.Local
+}
+
+// parseWMLVars splits a string into literal text and WML variable references
+// ($name, $(name) and $(name:conversion)). $$ is an escaped dollar sign.
+func parseWMLVars(s string) ([]wmlPart, error) {
+	var parts []wmlPart
+	var text strings.Builder
+
+	flush := func() {
+		if text.Len() > 0 {
+			parts = append(parts, wmlPart{Text: text.String()})
+			text.Reset()
+		}
+	}
+
+	for i := 0; i < len(s); i++ {
+		if s[i] != '$' {
+			text.WriteByte(s[i])
+			continue
+		}
+		if i+1 < len(s) && s[i+1] == '$' {
+			text.WriteByte('$')
+			i++
+			continue
+		}
+
+		var name, conv string
+		if i+1 < len(s) && s[i+1] == '(' {
+			end := strings.IndexByte(s[i:], ')')
+			if end < 0 {
+				return nil, fmt.Errorf("unterminated variable reference in %q", s)
+			}
+			name, conv, _ = strings.Cut(s[i+2:i+end], ":")
+			i += end
+		} else {
+			j := i + 1
+			for j < len(s) && isWMLVarChar(s[j], j == i+1) {
+				j++
+			}
+			name = s[i+1 : j]
+			i = j - 1
+		}
+		if name == "" {
+			return nil, fmt.Errorf("empty variable reference in %q", s)
+		}
+
+		part := wmlPart{Var: name, Conv: wmlConvNone}
+		switch conv {
+		case "", "n", "noesc":
+		case "e", "escape":
+			part.Conv = wmlConvEscape
+		case "u", "unesc":
+			part.Conv = wmlConvUnescape
+		default:
+			return nil, fmt.Errorf("unknown variable conversion %q in %q", conv, s)
+		}
+		flush()
+		parts = append(parts, part)
+	}
+	flush()
+
+	return parts, nil
+}
+
+// isWMLVarChar reports whether c may appear in a WML variable name
+func isWMLVarChar(c byte, first bool) bool {
+	if c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') {
+		return true
+	}
+	return !first && c >= '0' && c <= '9'
+}
+
+// wbxmlEncoder holds the state for compiling a single WML deck
+type wbxmlEncoder struct {
+	strtbl  bytes.Buffer
+	offsets map[string]int
+	body    bytes.Buffer
+}
+
+// EncodeWMLC compiles a textual WML 1.1 deck into its WBXML (WMLC) form.
+// Strings that occur more than once are stored in the string table, everything else is inlined.
+func EncodeWMLC(deck []byte) ([]byte, error) {
+	root, err := parseWMLDeck(deck)
+	if err != nil {
+		return nil, err
+	}
+
+	enc := &wbxmlEncoder{offsets: make(map[string]int)}
+	enc.buildStringTable(root)
+	enc.encodeNode(root)
+
+	var out bytes.Buffer
+	out.WriteByte(wbxmlVersion11)
+	writeMultiByteUint(&out, wbxmlPublicID)
+	writeMultiByteUint(&out, wbxmlUTF8)
+	writeMultiByteUint(&out, uint32(enc.strtbl.Len()))
+	out.Write(enc.strtbl.Bytes())
+	out.Write(enc.body.Bytes())
+
+	return out.Bytes(), nil
+}
+
+// buildStringTable adds repeated strings and unknown tag and attribute names to the string table
+func (e *wbxmlEncoder) buildStringTable(root *wmlNode) {
+	counts := make(map[string]int)
+	required := make(map[string]bool)
+	var order []string
+	seen := func(s string) {
+		if counts[s] == 0 {
+			order = append(order, s)
+		}
+		counts[s]++
+	}
+
+	var walk func(n *wmlNode)
+	walk = func(n *wmlNode) {
+		if n.Name == "" {
+			for _, part := range n.Text {
+				if part.Text != "" {
+					seen(part.Text)
+				}
+			}
+			return
+		}
+		if _, ok := wmlTagTokens[n.Name]; !ok {
+			// Literal tags can only refer to the string table
+			seen(n.Name)
+			required[n.Name] = true
+		}
+		for _, attr := range n.Attrs {
+			start, rest := matchAttrStart(attr)
+			if start == nil {
+				seen(attr.Name)
+				required[attr.Name] = true
+			}
+			for _, part := range rest {
+				if part.Text != "" {
+					seen(part.Text)
+				}
+			}
+		}
+		for _, child := range n.Children {
+			walk(child)
+		}
+	}
+	walk(root)
+
+	for _, s := range order {
+		if !required[s] && (counts[s] < 2 || len(s) < wmlcMinSharedLength) {
+			continue
+		}
+		e.offsets[s] = e.strtbl.Len()
+		e.strtbl.WriteString(s)
+		e.strtbl.WriteByte(0)
+	}
+}
+
+// matchAttrStart finds the attribute start token with the longest matching value prefix
+// and returns it together with the remainder of the value
+func matchAttrStart(attr wmlAttr) (*wmlAttrStart, []wmlPart) {
+	first := ""
+	if len(attr.Value) > 0 {
+		first = attr.Value[0].Text
+	}
+
+	var best *wmlAttrStart
+	for i := range wmlAttrStartTokens {
+		start := &wmlAttrStartTokens[i]
+		if start.Name != attr.Name || !strings.HasPrefix(first, start.Prefix) {
+			continue
+		}
+		if best == nil || len(start.Prefix) > len(best.Prefix) {
+			best = start
+		}
+	}
+	if best == nil || best.Prefix == "" {
+		return best, attr.Value
+	}
+
+	rest := append([]wmlPart{}, attr.Value...)
+	rest[0].Text = strings.TrimPrefix(rest[0].Text, best.Prefix)
+	if rest[0].Text == "" {
+		rest = rest[1:]
+	}
+	return best, rest
+}
+
+// encodeNode writes an element or text node to the body
+func (e *wbxmlEncoder) encodeNode(n *wmlNode) {
+	if n.Name == "" {
+		e.encodeParts(n.Text)
+		return
+	}
+
+	token, known := wmlTagTokens[n.Name]
+	if !known {
+		token = wbxmlLiteral
+	}
+	if len(n.Attrs) > 0 {
+		token |= wbxmlHasAttributes
+	}
+	if len(n.Children) > 0 {
+		token |= wbxmlHasContent
+	}
+	e.body.WriteByte(token)
+	if !known {
+		writeMultiByteUint(&e.body, uint32(e.offsets[n.Name]))
+	}
+
+	if len(n.Attrs) > 0 {
+		for _, attr := range n.Attrs {
+			start, rest := matchAttrStart(attr)
+			if start != nil {
+				e.body.WriteByte(start.Token)
+			} else {
+				e.body.WriteByte(wbxmlLiteral)
+				writeMultiByteUint(&e.body, uint32(e.offsets[attr.Name]))
+			}
+			e.encodeParts(rest)
+		}
+		e.body.WriteByte(wbxmlEnd)
+	}
+
+	if len(n.Children) > 0 {
+		for _, child := range n.Children {
+			e.encodeNode(child)
+		}
+		e.body.WriteByte(wbxmlEnd)
+	}
+}
+
+// encodeParts writes literal text as string references and variables as extension tokens
+func (e *wbxmlEncoder) encodeParts(parts []wmlPart) {
+	for _, part := range parts {
+		if part.Var != "" {
+			e.body.WriteByte(byte(wbxmlExtI0 + part.Conv))
+			e.body.WriteString(part.Var)
+			e.body.WriteByte(0)
+			continue
+		}
+		if offset, ok := e.offsets[part.Text]; ok {
+			e.body.WriteByte(wbxmlStrT)
+			writeMultiByteUint(&e.body, uint32(offset))
+			continue
+		}
+		e.body.WriteByte(wbxmlStrI)
+		e.body.WriteString(part.Text)
+		e.body.WriteByte(0)
+	}
+}
+
+// writeMultiByteUint writes a WBXML mb_u_int32: 7 bits per byte, most significant first
+func writeMultiByteUint(buf *bytes.Buffer, v uint32) {
+	var tmp [5]byte
+	i := len(tmp) - 1
+	tmp[i] = byte(v & 0x7F)
+	for v >>= 7; v > 0; v >>= 7 {
+		i--
+		tmp[i] = byte(v&0x7F) | 0x80
+	}
+	buf.Write(tmp[i:])
+}
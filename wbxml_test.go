@@ -0,0 +1,411 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"text/template"
+)
+
+// wbxmlDecoder decodes the WMLC produced by EncodeWMLC back into a wmlNode tree
+type wbxmlDecoder struct {
+	data   []byte
+	pos    int
+	strtbl []byte
+}
+
+func decodeWMLC(data []byte) (*wmlNode, error) {
+	d := &wbxmlDecoder{data: data}
+
+	version, err := d.byte()
+	if err != nil {
+		return nil, err
+	}
+	if version != wbxmlVersion11 {
+		return nil, fmt.Errorf("unexpected version 0x%02x", version)
+	}
+	if publicID, err := d.mbUint(); err != nil || publicID != wbxmlPublicID {
+		return nil, fmt.Errorf("unexpected public id %d: %v", publicID, err)
+	}
+	if charset, err := d.mbUint(); err != nil || charset != wbxmlUTF8 {
+		return nil, fmt.Errorf("unexpected charset %d: %v", charset, err)
+	}
+	length, err := d.mbUint()
+	if err != nil {
+		return nil, err
+	}
+	if d.pos+int(length) > len(d.data) {
+		return nil, fmt.Errorf("string table exceeds document")
+	}
+	d.strtbl = d.data[d.pos : d.pos+int(length)]
+	d.pos += int(length)
+
+	token, err := d.byte()
+	if err != nil {
+		return nil, err
+	}
+	root, err := d.element(token)
+	if err != nil {
+		return nil, err
+	}
+	if d.pos != len(d.data) {
+		return nil, fmt.Errorf("%d trailing bytes", len(d.data)-d.pos)
+	}
+	return root, nil
+}
+
+func (d *wbxmlDecoder) byte() (byte, error) {
+	if d.pos >= len(d.data) {
+		return 0, fmt.Errorf("unexpected end of document")
+	}
+	b := d.data[d.pos]
+	d.pos++
+	return b, nil
+}
+
+func (d *wbxmlDecoder) mbUint() (uint32, error) {
+	var v uint32
+	for i := 0; i < 5; i++ {
+		b, err := d.byte()
+		if err != nil {
+			return 0, err
+		}
+		v = v<<7 | uint32(b&0x7F)
+		if b&0x80 == 0 {
+			return v, nil
+		}
+	}
+	return 0, fmt.Errorf("mb_u_int32 too long")
+}
+
+func (d *wbxmlDecoder) inlineString() (string, error) {
+	end := bytes.IndexByte(d.data[d.pos:], 0)
+	if end < 0 {
+		return "", fmt.Errorf("unterminated inline string")
+	}
+	s := string(d.data[d.pos : d.pos+end])
+	d.pos += end + 1
+	return s, nil
+}
+
+func (d *wbxmlDecoder) tableString() (string, error) {
+	offset, err := d.mbUint()
+	if err != nil {
+		return "", err
+	}
+	if int(offset) >= len(d.strtbl) {
+		return "", fmt.Errorf("string table offset %d out of range", offset)
+	}
+	end := bytes.IndexByte(d.strtbl[offset:], 0)
+	if end < 0 {
+		return "", fmt.Errorf("unterminated string table entry")
+	}
+	return string(d.strtbl[offset : int(offset)+end]), nil
+}
+
+// part decodes a string or variable token, reporting false if the token is neither
+func (d *wbxmlDecoder) part(token byte) (wmlPart, bool, error) {
+	switch token {
+	case wbxmlStrI:
+		s, err := d.inlineString()
+		return wmlPart{Text: s}, true, err
+	case wbxmlStrT:
+		s, err := d.tableString()
+		return wmlPart{Text: s}, true, err
+	case wbxmlExtI0, wbxmlExtI1, wbxmlExtI2:
+		s, err := d.inlineString()
+		return wmlPart{Var: s, Conv: int(token - wbxmlExtI0)}, true, err
+	case wbxmlExtT0, wbxmlExtT1, wbxmlExtT2:
+		s, err := d.tableString()
+		return wmlPart{Var: s, Conv: int(token - wbxmlExtT0)}, true, err
+	case wbxmlEntity:
+		code, err := d.mbUint()
+		return wmlPart{Text: string(rune(code))}, true, err
+	}
+	return wmlPart{}, false, nil
+}
+
+func (d *wbxmlDecoder) element(token byte) (*wmlNode, error) {
+	node := &wmlNode{}
+	switch tag := token &^ (wbxmlHasAttributes | wbxmlHasContent); tag {
+	case wbxmlLiteral:
+		name, err := d.tableString()
+		if err != nil {
+			return nil, err
+		}
+		node.Name = name
+	default:
+		name, ok := wmlTagNamesByToken()[tag]
+		if !ok {
+			return nil, fmt.Errorf("unknown tag token 0x%02x", tag)
+		}
+		node.Name = name
+	}
+
+	if token&wbxmlHasAttributes != 0 {
+		starts := wmlAttrStartsByToken()
+		for {
+			t, err := d.byte()
+			if err != nil {
+				return nil, err
+			}
+			if t == wbxmlEnd {
+				break
+			}
+			if part, ok, err := d.part(t); ok || err != nil {
+				if err != nil {
+					return nil, err
+				}
+				if len(node.Attrs) == 0 {
+					return nil, fmt.Errorf("attribute value without attribute start")
+				}
+				attr := &node.Attrs[len(node.Attrs)-1]
+				attr.Value = appendPart(attr.Value, part)
+				continue
+			}
+			if value, ok := wmlAttrValueTokens[t]; ok {
+				attr := &node.Attrs[len(node.Attrs)-1]
+				attr.Value = appendPart(attr.Value, wmlPart{Text: value})
+				continue
+			}
+			if t == wbxmlLiteral {
+				name, err := d.tableString()
+				if err != nil {
+					return nil, err
+				}
+				node.Attrs = append(node.Attrs, wmlAttr{Name: name})
+				continue
+			}
+			start, ok := starts[t]
+			if !ok {
+				return nil, fmt.Errorf("unknown attribute token 0x%02x", t)
+			}
+			attr := wmlAttr{Name: start.Name}
+			if start.Prefix != "" {
+				attr.Value = []wmlPart{{Text: start.Prefix}}
+			}
+			node.Attrs = append(node.Attrs, attr)
+		}
+	}
+
+	if token&wbxmlHasContent != 0 {
+		for {
+			t, err := d.byte()
+			if err != nil {
+				return nil, err
+			}
+			if t == wbxmlEnd {
+				break
+			}
+			part, ok, err := d.part(t)
+			if err != nil {
+				return nil, err
+			}
+			if ok {
+				if n := len(node.Children); n > 0 && node.Children[n-1].Name == "" {
+					node.Children[n-1].Text = appendPart(node.Children[n-1].Text, part)
+				} else {
+					node.Children = append(node.Children, &wmlNode{Text: []wmlPart{part}})
+				}
+				continue
+			}
+			child, err := d.element(t)
+			if err != nil {
+				return nil, err
+			}
+			node.Children = append(node.Children, child)
+		}
+	}
+
+	return node, nil
+}
+
+// appendPart appends a part, merging adjacent literal text
+func appendPart(parts []wmlPart, part wmlPart) []wmlPart {
+	if n := len(parts); n > 0 && part.Var == "" && parts[n-1].Var == "" {
+		parts[n-1].Text += part.Text
+		return parts
+	}
+	return append(parts, part)
+}
+
+// wmlAttrValueTokens is the WML 1.1 attribute value code page 0.
+// EncodeWMLC writes values as strings, but other compilers and gateways use these tokens.
+var wmlAttrValueTokens = map[byte]string{
+	0x85: ".com/",
+	0x86: ".edu/",
+	0x87: ".net/",
+	0x88: ".org/",
+	0x89: "accept",
+	0x8A: "bottom",
+	0x8B: "clear",
+	0x8C: "delete",
+	0x8D: "help",
+	0x8E: "http://",
+	0x8F: "http://www.",
+	0x90: "https://",
+	0x91: "https://www.",
+	0x93: "middle",
+	0x94: "nowrap",
+	0x95: "onpick",
+	0x96: "onenterbackward",
+	0x97: "onenterforward",
+	0x98: "ontimer",
+	0x99: "options",
+	0x9A: "password",
+	0x9B: "reset",
+	0x9D: "text",
+	0x9E: "top",
+	0x9F: "unknown",
+	0xA0: "wrap",
+	0xA1: "www.",
+}
+
+func wmlTagNamesByToken() map[byte]string {
+	index := make(map[byte]string, len(wmlTagTokens))
+	for name, token := range wmlTagTokens {
+		index[token] = name
+	}
+	return index
+}
+
+func wmlAttrStartsByToken() map[byte]wmlAttrStart {
+	index := make(map[byte]wmlAttrStart, len(wmlAttrStartTokens))
+	for _, start := range wmlAttrStartTokens {
+		index[start.Token] = start
+	}
+	return index
+}
+
+func TestEncodeWMLCTokens(t *testing.T) {
+	deck := `<?xml version="1.0"?><wml><card id="c1" newcontext="true"><p>Hi $(name)!</p></card></wml>`
+	expected := []byte{
+		// version, public id, charset, empty string table
+		0x01, 0x04, 0x6A, 0x00,
+		// <wml><card id="c1" newcontext="true"><p>
+		0x7F, 0xE7, 0x55, 0x03, 'c', '1', 0x00, 0x23, 0x01, 0x60,
+		// Hi $(name)!
+		0x03, 'H', 'i', ' ', 0x00, 0x42, 'n', 'a', 'm', 'e', 0x00, 0x03, '!', 0x00,
+		// </p></card></wml>
+		0x01, 0x01, 0x01,
+	}
+
+	result, err := EncodeWMLC([]byte(deck))
+	if err != nil {
+		t.Fatalf("EncodeWMLC failed: %v", err)
+	}
+	if !bytes.Equal(result, expected) {
+		t.Errorf("EncodeWMLC = % x, expected % x", result, expected)
+	}
+}
+
+func TestEncodeWMLCRoundTrip(t *testing.T) {
+	tmpl := template.Must(template.New("index.wml").Funcs(wmlFuncs).ParseFiles(filepath.Join("templates", "index.wml")))
+	var index bytes.Buffer
+	err := tmpl.Execute(&index, TemplateData{
+		PoWChallenge:  "wml-abcdef",
+		PoWDifficulty: 2,
+		FullURL:       "http://example.com/?a=1&b=$2",
+		ErrorMessage:  "<b>ünïcode</b> & $(evil)",
+	})
+	if err != nil {
+		t.Fatalf("failed to render index.wml: %v", err)
+	}
+
+	notFound, err := os.ReadFile(filepath.Join("templates", "404.wml"))
+	if err != nil {
+		t.Fatalf("failed to read 404.wml: %v", err)
+	}
+
+	decks := map[string][]byte{
+		"index.wml": index.Bytes(),
+		"404.wml":   notFound,
+		"prefixes":  []byte(`<wml><card><do type="accept"><go href="http://wap.fyi/$(path:e)" method="post"/></do><p align="center">a&nbsp;b</p></card></wml>`),
+		"literals":  []byte(`<wml><card><blink style="x">x</blink><blink style="x">$name $$5</blink></card></wml>`),
+	}
+
+	for name, deck := range decks {
+		expected, err := parseWMLDeck(deck)
+		if err != nil {
+			t.Fatalf("%s: parseWMLDeck failed: %v", name, err)
+		}
+		wmlc, err := EncodeWMLC(deck)
+		if err != nil {
+			t.Fatalf("%s: EncodeWMLC failed: %v", name, err)
+		}
+		decoded, err := decodeWMLC(wmlc)
+		if err != nil {
+			t.Fatalf("%s: decodeWMLC failed: %v", name, err)
+		}
+		if !reflect.DeepEqual(decoded, expected) {
+			t.Errorf("%s: round trip mismatch\ngot:      %s\nexpected: %s", name, dumpWMLNode(decoded), dumpWMLNode(expected))
+		}
+	}
+}
+
+func TestEncodeWMLCStringTable(t *testing.T) {
+	deck := `<wml><card><p>repeated text</p><p>repeated text</p><p>once only</p></card></wml>`
+
+	wmlc, err := EncodeWMLC([]byte(deck))
+	if err != nil {
+		t.Fatalf("EncodeWMLC failed: %v", err)
+	}
+	if !bytes.Contains(wmlc, []byte("\x0erepeated text\x00")) {
+		t.Errorf("repeated text was not moved to the string table: % x", wmlc)
+	}
+	if bytes.Count(wmlc, []byte("repeated text")) != 1 {
+		t.Errorf("repeated text should only be stored once: % x", wmlc)
+	}
+	if !bytes.Contains(wmlc, []byte{wbxmlStrI, 'o', 'n', 'c', 'e'}) {
+		t.Errorf("unique text should be inlined: % x", wmlc)
+	}
+}
+
+func TestParseWMLVars(t *testing.T) {
+	testCases := []struct {
+		input    string
+		expected []wmlPart
+	}{
+		{"plain", []wmlPart{{Text: "plain"}}},
+		{"$$5", []wmlPart{{Text: "$5"}}},
+		{"a$(b)c", []wmlPart{{Text: "a"}, {Var: "b", Conv: wmlConvNone}, {Text: "c"}}},
+		{"$(b:e)", []wmlPart{{Var: "b", Conv: wmlConvEscape}}},
+		{"$(b:unesc)", []wmlPart{{Var: "b", Conv: wmlConvUnescape}}},
+		{"$b_1 x", []wmlPart{{Var: "b_1", Conv: wmlConvNone}, {Text: " x"}}},
+	}
+
+	for _, tc := range testCases {
+		result, err := parseWMLVars(tc.input)
+		if err != nil {
+			t.Errorf("parseWMLVars(%q) failed: %v", tc.input, err)
+			continue
+		}
+		if !reflect.DeepEqual(result, tc.expected) {
+			t.Errorf("parseWMLVars(%q) = %v, expected %v", tc.input, result, tc.expected)
+		}
+	}
+
+	for _, input := range []string{"$(unterminated", "$(b:bogus)", "$ alone"} {
+		if _, err := parseWMLVars(input); err == nil {
+			t.Errorf("parseWMLVars(%q) should fail", input)
+		}
+	}
+}
+
+func dumpWMLNode(n *wmlNode) string {
+	if n.Name == "" {
+		return fmt.Sprintf("%v", n.Text)
+	}
+	s := "<" + n.Name
+	for _, attr := range n.Attrs {
+		s += fmt.Sprintf(" %s=%v", attr.Name, attr.Value)
+	}
+	s += ">"
+	for _, child := range n.Children {
+		s += dumpWMLNode(child)
+	}
+	return s + "</" + n.Name + ">"
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"bytes"
+	"encoding/xml"
+	"log"
+	"mime"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"text/template"
+
+	"github.com/labstack/echo/v4"
+)
+
+// wmlContentType is the MIME type of textual WML decks
+const wmlContentType = "text/vnd.wap.wml"
+
+func init() {
+	// Make sure WAP assets in the templates directory are served with their WAP MIME types
+	mime.AddExtensionType(".wml", wmlContentType)
+	mime.AddExtensionType(".wmls", "text/vnd.wap.wmlscript")
+	mime.AddExtensionType(".wbmp", "image/vnd.wap.wbmp")
+}
+
+// wmlFuncs holds the template functions available to WML templates
+var wmlFuncs = template.FuncMap{
+	"wml": escapeWML,
+}
+
+// acceptsWML reports whether the client accepts WAP content, either textual or binary WML
+func acceptsWML(c echo.Context) bool {
+	acceptHeader := c.Request().Header.Get("Accept")
+	return strings.Contains(acceptHeader, wmlContentType) || strings.Contains(acceptHeader, wmlcContentType)
+}
+
+// acceptsWMLC reports whether the client accepts binary WML
+func acceptsWMLC(c echo.Context) bool {
+	return strings.Contains(c.Request().Header.Get("Accept"), wmlcContentType)
+}
+
+// escapeWML escapes a string for use in WML text and attribute values.
+// Besides the usual XML escaping, $ is doubled so user input can never be
+// interpreted as a WML variable reference.
+func escapeWML(s string) string {
+	var b strings.Builder
+	xml.EscapeText(&b, []byte(s))
+	return strings.ReplaceAll(b.String(), "$", "$$")
+}
+
+// renderWML renders the named WML template from the templates directory.
+// WML templates use text/template, so every user supplied value must be piped through "wml".
+func renderWML(c echo.Context, code int, name string, data interface{}) error {
+	tmpl := template.Must(template.New(name).Funcs(wmlFuncs).ParseFiles(filepath.Join("templates", name)))
+
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return err
+	}
+
+	return writeWML(c, code, buf.Bytes())
+}
+
+// writeWML sends a WML deck, compiled to WMLC when the client accepts it
+func writeWML(c echo.Context, code int, deck []byte) error {
+	if acceptsWMLC(c) {
+		wmlc, err := EncodeWMLC(deck)
+		if err == nil {
+			return c.Blob(code, wmlcContentType, wmlc)
+		}
+		// Fall back to textual WML, the gateway may still be able to compile it
+		log.Printf("Failed to encode WMLC: %v", err)
+	}
+
+	return c.Blob(code, wmlContentType, deck)
+}
+
+// renderIndexWML renders the index.wml deck with the provided data
+func renderIndexWML(c echo.Context, data TemplateData) error {
+	return renderWML(c, http.StatusOK, "index.wml", data)
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// wmlSolveCall finds the challenge and difficulty the deck hands to the WMLScript solver
+var wmlSolveCall = regexp.MustCompile(`captcha\.wmls#solve\('([^']*)',(\d+),`)
+
+// checkWMLDeck checks that rec holds a textual WML deck and returns its challenge and difficulty
+func checkWMLDeck(t *testing.T, rec *httptest.ResponseRecorder) (string, int) {
+	t.Helper()
+	if rec.Code != http.StatusOK {
+		t.Fatalf("WML response = %d, expected %d", rec.Code, http.StatusOK)
+	}
+	if contentType := rec.Header().Get("Content-Type"); contentType != wmlContentType {
+		t.Fatalf("WML response has Content-Type %q, expected %q", contentType, wmlContentType)
+	}
+	root, err := parseWMLDeck(rec.Body.Bytes())
+	if err != nil {
+		t.Fatalf("WML response is not a valid deck: %v\n%s", err, rec.Body.String())
+	}
+	if root.Name != "wml" || len(root.Children) != 2 {
+		t.Fatalf("WML response has %d cards in <%s>, expected the shorten and send cards", len(root.Children), root.Name)
+	}
+
+	match := wmlSolveCall.FindStringSubmatch(rec.Body.String())
+	if match == nil {
+		t.Fatalf("WML deck has no challenge:\n%s", rec.Body.String())
+	}
+	difficulty, _ := strconv.Atoi(match[2])
+	if difficulty < WMLProfile.Difficulty || difficulty > WMLProfile.MaxDifficulty {
+		t.Errorf("WML challenge difficulty = %d, expected between %d and %d", difficulty, WMLProfile.Difficulty, WMLProfile.MaxDifficulty)
+	}
+	return match[1], difficulty
+}
+
+func TestWMLDeck(t *testing.T) {
+	e := newTestServer(t)
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("Accept", wmlContentType+", text/vnd.wap.wmlscript, */*")
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+
+	challenge, _ := checkWMLDeck(t, rec)
+	if challenge == "" {
+		t.Errorf("WML deck has an empty challenge")
+	}
+}
+
+func TestWMLShorten(t *testing.T) {
+	e := newTestServer(t)
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("Accept", wmlContentType)
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	challenge, difficulty := checkWMLDeck(t, rec)
+
+	form := url.Values{
+		"fullURL":       {"example.com/wap"},
+		"path":          {"wap-deck"},
+		"lifetime":      {"1d"},
+		"slug_style":    {""},
+		"pow_challenge": {challenge},
+		"pow_solution":  {strconv.Itoa(solveChallenge(challenge, difficulty))},
+	}
+	rec = doForm(e, "/shorten.wml", form)
+	next, _ := checkWMLDeck(t, rec)
+	if !strings.Contains(rec.Body.String(), "<b>Success:</b>") || !strings.Contains(rec.Body.String(), "wap-deck") {
+		t.Errorf("solved challenge did not return the success card:\n%s", rec.Body.String())
+	}
+	if next == challenge {
+		t.Errorf("success card reuses the spent challenge")
+	}
+	if link, exists, _ := linkStore.GetLink(context.Background(), "wap-deck"); !exists || link.URL != "http://example.com/wap" {
+		t.Errorf("GetLink(wap-deck) = %+v, %t, expected the shortened URL", link, exists)
+	}
+
+	// The challenge is spent now, and a wrong solution is refused too
+	for name, values := range map[string]url.Values{
+		"spent challenge": form,
+		"wrong solution":  {"fullURL": {"example.com"}, "pow_challenge": {next}, "pow_solution": {"x"}},
+	} {
+		rec = doForm(e, "/shorten.wml", values)
+		checkWMLDeck(t, rec)
+		if !strings.Contains(rec.Body.String(), "<b>Error:</b>") {
+			t.Errorf("%s did not return the error card:\n%s", name, rec.Body.String())
+		}
+	}
+}